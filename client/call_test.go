@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/acoinfo/vsoa/protocol"
+	"github.com/acoinfo/vsoa/server"
+)
+
+func TestCallContextCancel(t *testing.T) {
+	s := server.NewServer("test", server.Option{})
+	release := make(chan struct{})
+	s.On("/block", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		<-release
+	})
+	s.On("/echo", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		res.Param = req.Param
+	})
+	addr := startTestServer(t, s)
+	defer s.Close()
+	defer close(release)
+
+	c := NewClient(Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	expired, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	for _, tt := range []struct {
+		ctx  context.Context
+		want error
+	}{
+		{canceled, context.Canceled},
+		{expired, context.DeadlineExceeded},
+	} {
+		_, err := c.CallContext(tt.ctx, "/block", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage())
+		if err != tt.want {
+			t.Fatalf("blocked call: got %v, want %v", err, tt.want)
+		}
+		c.mutex.Lock()
+		n := len(c.pending)
+		c.mutex.Unlock()
+		if n != 0 {
+			t.Fatalf("%d calls still pending after %v", n, tt.want)
+		}
+	}
+
+	// a context that is done before the call is not sent
+	if _, err := c.CallContext(canceled, "/echo", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage()); err != context.Canceled {
+		t.Fatalf("call with canceled context: got %v, want %v", err, context.Canceled)
+	}
+
+	req := protocol.NewMessage()
+	req.Param = []byte(`"ok"`)
+	reply, err := c.CallContext(context.Background(), "/echo", protocol.TypeRPC, protocol.RpcMethodGet, req)
+	if err != nil {
+		t.Fatalf("call after cancel: %v", err)
+	}
+	if string(reply.Param) != `"ok"` {
+		t.Fatalf("call after cancel: got %q", reply.Param)
+	}
+}
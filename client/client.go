@@ -10,6 +10,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/acoinfo/vsoa/protocol"
@@ -34,11 +35,21 @@ func (s strErr) IsServiceError() bool {
 }
 
 func defaultOnConnect(c *Client) {
-	log.Printf("Client %s connected to %s", c.Conn.RemoteAddr(), c.addr)
+	c.mutex.Lock()
+	conn, addr := c.Conn, c.addr
+	c.mutex.Unlock()
+	if conn != nil {
+		log.Printf("Client %s connected to %s", conn.RemoteAddr(), addr)
+	}
 }
 
 func defaultOnDisconnect(c *Client) {
-	log.Printf("Client %s disconnected from %s", c.Conn.RemoteAddr(), c.addr)
+	c.mutex.Lock()
+	conn, addr := c.Conn, c.addr
+	c.mutex.Unlock()
+	if conn != nil {
+		log.Printf("Client %s disconnected from %s", conn.RemoteAddr(), addr)
+	}
 }
 
 // DefaultOption is a common option configuration for client.
@@ -59,6 +70,8 @@ var DefaultOption = Option{
 // ErrShutdown connection is closed.
 var (
 	ErrShutdown         = errors.New("connection is shut down")
+	ErrQuickEncryption  = errors.New("quick channel encryption needs TLS and server support")
+	ErrServerShutdown   = errors.New("server is shutting down")
 	ErrUnAuthed         = errors.New("client is not Authed")
 	ErrUnsupportedCodec = errors.New("unsupported codec")
 	ErrPingEcho         = errors.New("PingEcho set error")
@@ -77,11 +90,13 @@ const (
 // VsoaClient is interface that defines one client to call one server.
 type VsoaClient interface {
 	// connect & shack hand with VSOA server
-	Connect(network, address string) error
+	Connect(vsoa_or_VSOA_URL, address_or_URL string) (ServerInfo string, err error)
 	// async func for VSOA call
-	Go(mt protocol.MessageType, URL string, serviceMethod protocol.RpcMessageType, param *json.RawMessage, data []byte, done chan *Call) *Call
+	Go(URL string, mt protocol.MessageType, flags any, req *protocol.Message, reply *protocol.Message, done chan *Call) *Call
 	// sync func for VSOA call
-	Call(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error
+	Call(URL string, mt protocol.MessageType, flags any, req *protocol.Message) (*protocol.Message, error)
+	// sync func for VSOA call with cancellation and deadline
+	CallContext(ctx context.Context, URL string, mt protocol.MessageType, flags any, req *protocol.Message) (*protocol.Message, error)
 
 	// Close the client & release the resources
 	Close() error
@@ -149,10 +164,21 @@ type Client struct {
 	authed           bool  // if server authed this client
 	closing          bool  // user has called Close
 	shutdown         bool  // server has told us to stop
+	reconnecting     bool  // reconnect is running
 	pingTimeoutCount int32 // for server ping echo logic
 	hasRegulator     bool  // for checking regulator is active
+	interceptors     []Interceptor
+	writer           *connWriter           // serializes normal channel writes
+	offline          []*offlineCall        // calls queued while reconnecting
+	quickCipher      *protocol.QuickCipher // seals the quick channel, nil if plaintext
+	quickSigner      *protocol.QuickSigner // signs quick packets with the token given by server
+	codec            protocol.Codec        // Param codec of typed calls, given by server
 
 	ServerMessageChan chan<- *protocol.Message
+
+	stateMu  sync.Mutex // protects following, never locks mutex inside it
+	state    State
+	watchers map[chan State]struct{} // WatchState channels
 }
 
 // NewClient returns a new Client with the option.
@@ -163,6 +189,9 @@ func NewClient(option Option) *Client {
 	if option.ReconnectInterval == 0 {
 		option.ReconnectInterval = DefaultOption.ReconnectInterval
 	}
+	if option.OfflineCallTimeout == 0 {
+		option.OfflineCallTimeout = DefaultOfflineCallTimeout
+	}
 	if option.PingInterval == 0 {
 		option.PingInterval = DefaultOption.PingInterval
 	}
@@ -186,6 +215,7 @@ func NewClient(option Option) *Client {
 		option:           option,
 		authed:           false,
 		pingTimeoutCount: 0,
+		interceptors:     append([]Interceptor(nil), option.Interceptors...),
 	}
 }
 
@@ -195,13 +225,38 @@ func (c *Client) clearClient() {
 	c.authed = false
 	c.closing = false
 	c.shutdown = false
-	c.pingTimeoutCount = 0
+	atomic.StoreInt32(&c.pingTimeoutCount, 0)
 }
 
 func (c *Client) GetUid() uint32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.uid
 }
 
+// RemoteAddr returns the server address this client connects to.
+func (c *Client) RemoteAddr() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.addr
+}
+
+// autoReconnect reports whether Option.AutoReconnect is still set,
+// Delete clears it.
+func (c *Client) autoReconnect() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.option.AutoReconnect
+}
+
+// pendingCount returns the number of calls waiting for reply.
+func (c *Client) pendingCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.pending)
+}
+
 // Option contains all options for creating clients.
 type Option struct {
 	Password          string
@@ -212,10 +267,37 @@ type Option struct {
 	ConnectTimeout    time.Duration
 	AutoReconnect     bool
 	ReconnectInterval time.Duration
+	// ReconnectPolicy sets the backoff and max attempts of retries,
+	// nil means retry forever every ReconnectInterval.
+	ReconnectPolicy *ReconnectPolicy
 	// TLSConfig for tcp and quic
-	TLSConfig    *tls.Config
+	TLSConfig *tls.Config
+	// QoS settings requested after every connect, nil means server default
+	Qos *protocol.QosSetupParam
+	// Interceptors wrap every outgoing call, the first one is the outermost
+	Interceptors []Interceptor
+	// Credentials is called before every ServInfo handshake, it replaces
+	// Password if it is set.
+	Credentials CredentialProvider
+	// OfflineQueueSize is the max number of RPC and DATAGRAM calls queued
+	// while reconnecting, they are sent after the ServInfo handshake.
+	// 0 means calls fail with ErrShutdown while reconnecting.
+	OfflineQueueSize int
+	// OfflineCallTimeout is how long a call can wait in the offline queue,
+	// default DefaultOfflineCallTimeout.
+	OfflineCallTimeout time.Duration
+	// QuickEncryption seals the quick channel with keys from the TLS
+	// session, Connect fails without TLS or if the server does not agree.
+	QuickEncryption bool
+	// Codecs are the Param codecs of typed calls, preferred first, they
+	// must be registered by protocol.RegisterCodec. Server selects one in
+	// ServInfo, JSON is used if none is supported by server.
+	Codecs       []string
 	OnConnect    func(c *Client)
 	OnDisconnect func(c *Client)
+	// OnReconnect is called after an automatic reconnect has subscribed
+	// the SubscribeList URLs again.
+	OnReconnect func(c *Client, ev ReconnectEvent)
 }
 
 // Call represents an active RPC.
@@ -229,6 +311,12 @@ type Call struct {
 	Reply         *protocol.Message
 	Error         error      // After completion, the error status.
 	Done          chan *Call // Strobes when call is complete.
+
+	seq      uint32 // pending seq, valid after the call is sent
+	canceled bool   // CallContext has given up this call, protected by client.mutex
+
+	failFast       bool          // not queued while reconnecting, set by WithFailFast
+	offlineTimeout time.Duration // set by WithOfflineTimeout
 }
 
 func (call *Call) done() {
@@ -266,8 +354,13 @@ func (client *Client) IsShutdown() bool {
 // the same Call object. If done is nil, Go will allocate a new channel.
 // If non-nil, done must be buffered or Go will deliberately crash.
 func (client *Client) Go(URL string, mt protocol.MessageType, flags any, req *protocol.Message, reply *protocol.Message, done chan *Call) *Call {
+	return client.goContext(context.Background(), URL, mt, flags, req, reply, done)
+}
+
+func (client *Client) goContext(ctx context.Context, URL string, mt protocol.MessageType, flags any, req *protocol.Message, reply *protocol.Message, done chan *Call) *Call {
 	call := new(Call)
 	call.URL = URL // prase the URL when go func "send"
+	call.VsoaType = mt
 
 	call.IsQuick = false
 	call.ServiceMethod = protocol.RpcMethodGet
@@ -290,6 +383,7 @@ func (client *Client) Go(URL string, mt protocol.MessageType, flags any, req *pr
 	call.Data = req.Data
 
 	call.Reply = reply
+	call.setOfflineOptions(ctx)
 	if done == nil {
 		done = make(chan *Call, 10) // buffered.
 	} else {
@@ -304,50 +398,116 @@ func (client *Client) Go(URL string, mt protocol.MessageType, flags any, req *pr
 	call.Done = done
 
 	switch mt {
+	case protocol.TypeServInfo, protocol.TypeRPC, protocol.TypeDatagram,
+		protocol.TypeSubscribe, protocol.TypeUnsubscribe, protocol.TypeQosSetup,
+		protocol.TypeNoop, protocol.TypePingEcho:
+	default:
+		return call // We just return done
+	}
+
+	// Keepalive calls are not intercepted
+	interceptors := client.interceptorList()
+	if len(interceptors) == 0 || mt == protocol.TypeNoop || mt == protocol.TypePingEcho {
+		go client.send(call)
+	} else {
+		go client.intercept(ctx, call, interceptors)
+	}
+
+	return call
+}
+
+// send sends call by its VSOA type.
+func (client *Client) send(call *Call) {
+	if client.enqueueOffline(call) {
+		return
+	}
+
+	switch call.VsoaType {
 	case protocol.TypeServInfo:
-		go client.sendSrvInfo(call) // Internal use mostly, But still user can call it,
+		client.sendSrvInfo(call) // Internal use mostly, But still user can call it,
 	case protocol.TypeRPC:
-		go client.sendRPC(call)
+		client.sendRPC(call)
 	case protocol.TypeDatagram:
-		go client.sendSingle(call)
+		client.sendSingle(call)
 	case protocol.TypeSubscribe:
-		go client.sendSubscribe(call, true)
+		client.sendSubscribe(call, true)
 	case protocol.TypeUnsubscribe:
-		go client.sendSubscribe(call, false)
+		client.sendSubscribe(call, false)
+	case protocol.TypeQosSetup:
+		client.sendQosSetup(call)
 	case protocol.TypeNoop:
-		go client.sendNoop(call)
+		client.sendNoop(call)
 	case protocol.TypePingEcho:
-		go client.sendPingEcho(call)
-	default:
-		return call // We just return done
+		client.sendPingEcho(call)
 	}
-
-	return call
 }
 
 // Call invokes the named function, waits for it to complete, and returns its error status.
 func (client *Client) Call(URL string, mt protocol.MessageType, flags any, req *protocol.Message) (*protocol.Message, error) {
-	return client.call(URL, mt, flags, req)
+	return client.call(context.Background(), URL, mt, flags, req)
 }
 
-func (client *Client) call(URL string, mt protocol.MessageType, flags any, req *protocol.Message) (*protocol.Message, error) {
+// CallContext invokes the named function like Call, but gives up when ctx is done.
+// In that case the pending call is removed and ctx.Err() is returned,
+// a late reply from server is dropped.
+func (client *Client) CallContext(ctx context.Context, URL string, mt protocol.MessageType, flags any, req *protocol.Message) (*protocol.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return client.call(ctx, URL, mt, flags, req)
+}
+
+func (client *Client) call(ctx context.Context, URL string, mt protocol.MessageType, flags any, req *protocol.Message) (*protocol.Message, error) {
 	reply := protocol.NewMessage()
 
-	Done := client.Go(URL, mt, flags, req, reply, make(chan *Call, 1)).Done
-	var err error
+	call := client.goContext(ctx, URL, mt, flags, req, reply, make(chan *Call, 1))
 
-	call := <-Done
-	err = call.Error
-	reply = call.Reply
+	select {
+	case call = <-call.Done:
+		return call.Reply, call.Error
+	case <-ctx.Done():
+		client.cancelCall(call)
+		return nil, ctx.Err()
+	}
+}
 
-	return reply, err
+// cancelCall removes call from pending, or stops it from being sent if it
+// is not sent yet.
+func (client *Client) cancelCall(call *Call) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	call.canceled = true
+	if c, ok := client.pending[call.seq]; ok && c == call {
+		delete(client.pending, call.seq)
+	}
 }
 
 // Client send SrvInfo message
 // Internal use for handshake with server.
 func (client *Client) sendSrvInfo(call *Call) {
+	m := &protocol.ServInfoReqParam{
+		Password:     client.option.Password,
+		PingInterval: client.option.PingInterval,
+		PingTimeout:  client.option.PingTimeout,
+		PingLost:     client.option.PingLost,
+	}
+	if client.option.Credentials != nil {
+		cred, err := client.option.Credentials()
+		if err != nil {
+			call.Error = err
+			call.done()
+			return
+		}
+		m.Password = cred.Password
+		m.Token = cred.Token
+	}
+
 	// Register this call.
+	m.Codecs = client.option.Codecs
+
 	client.mutex.Lock()
+	m.QuickAEAD = client.quickCipher != nil
 	if client.shutdown || client.closing {
 		call.Error = ErrShutdown
 		client.mutex.Unlock()
@@ -355,20 +515,20 @@ func (client *Client) sendSrvInfo(call *Call) {
 		return
 	}
 
-	if client.pending == nil {
-		client.pending = make(map[uint32]*Call)
+	if call.canceled {
+		// CallContext has given up this call
+		client.mutex.Unlock()
+		return
 	}
 
-	m := &protocol.ServInfoReqParam{
-		Password:     client.option.Password,
-		PingInterval: client.option.PingInterval,
-		PingTimeout:  client.option.PingTimeout,
-		PingLost:     client.option.PingLost,
+	if client.pending == nil {
+		client.pending = make(map[uint32]*Call)
 	}
 
 	seq := client.seq
 	client.seq++
 	client.pending[seq] = call
+	call.seq = seq
 
 	req := protocol.NewMessage()
 	if client.QConn == nil {
@@ -390,7 +550,7 @@ func (client *Client) sendSrvInfo(call *Call) {
 	}
 	client.mutex.Unlock()
 
-	_, err = client.Conn.Write(tmp)
+	err = client.writeFrame(tmp)
 
 	if err != nil {
 		if e, ok := err.(*net.OpError); ok {
@@ -416,7 +576,21 @@ func (client *Client) sendSrvInfo(call *Call) {
 
 // Client send RPC message
 func (client *Client) sendRPC(call *Call) {
-	// If it's RPC call Register this call.
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.TypeRPC)
+	req.SetMessageRpcMethod(call.ServiceMethod)
+
+	req.URL = []byte(call.URL)
+	req.Param = *call.Param
+	req.Data = call.Data
+
+	client.sendPending(call, req)
+}
+
+// sendPending registers call as waiting for reply, then writes req with
+// the sequence number of call to the normal channel.
+func (client *Client) sendPending(call *Call, req *protocol.Message) {
+	// Register this call.
 	client.mutex.Lock()
 	if client.shutdown || client.closing {
 		call.Error = ErrShutdown
@@ -425,6 +599,12 @@ func (client *Client) sendRPC(call *Call) {
 		return
 	}
 
+	if call.canceled {
+		// CallContext has given up this call
+		client.mutex.Unlock()
+		return
+	}
+
 	if client.pending == nil {
 		client.pending = make(map[uint32]*Call)
 	}
@@ -432,16 +612,10 @@ func (client *Client) sendRPC(call *Call) {
 	seq := client.seq
 	client.seq++
 	client.pending[seq] = call
+	call.seq = seq
 
-	req := protocol.NewMessage()
-	req.SetMessageType(protocol.TypeRPC)
-	req.SetMessageRpcMethod(call.ServiceMethod)
 	req.SetSeqNo(seq)
 
-	req.URL = []byte(call.URL)
-	req.Param = *call.Param
-	req.Data = call.Data
-
 	tmp, err := req.Encode(protocol.ChannelNormal)
 	if err != nil {
 		call = client.pending[seq]
@@ -453,7 +627,7 @@ func (client *Client) sendRPC(call *Call) {
 	}
 	client.mutex.Unlock()
 
-	_, err = client.Conn.Write(tmp)
+	err = client.writeFrame(tmp)
 
 	if err != nil {
 		if e, ok := err.(*net.OpError); ok {
@@ -507,12 +681,18 @@ func (client *Client) sendSingle(call *Call) {
 		call.done()
 		return
 	}
+	qc, qs, qconn := client.quickCipher, client.quickSigner, client.QConn
 	client.mutex.Unlock()
 
 	if call.IsQuick {
-		_, err = client.QConn.Write(tmp)
+		if qc != nil {
+			tmp = qc.Seal(tmp)
+		} else if qs != nil {
+			tmp = qs.Sign(tmp)
+		}
+		_, err = qconn.Write(tmp)
 	} else {
-		_, err = client.Conn.Write(tmp)
+		err = client.writeFrame(tmp)
 	}
 
 	if err != nil {
@@ -539,11 +719,13 @@ func (client *Client) handleServerRequest(msg *protocol.Message) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("ServerMessageChan may be closed so client remove it. Please add it again if you want to handle server requests. error is %v", r)
+			client.mutex.Lock()
 			client.ServerMessageChan = nil
+			client.mutex.Unlock()
 		}
 	}()
 
-	serverMessageChan := client.ServerMessageChan
+	serverMessageChan := client.serverMessageChan()
 	if serverMessageChan != nil {
 		select {
 		case serverMessageChan <- msg:
@@ -553,28 +735,58 @@ func (client *Client) handleServerRequest(msg *protocol.Message) {
 	}
 }
 
+func (client *Client) serverMessageChan() chan<- *protocol.Message {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.ServerMessageChan
+}
+
+// serverShutdown fails all pending calls after the server sent a shutdown
+// notice, their replies will never come. The notice goes to ServerMessageChan.
+func (client *Client) serverShutdown(notice *protocol.Message) {
+	client.mutex.Lock()
+	pending := client.pending
+	client.pending = nil
+	client.mutex.Unlock()
+
+	for _, call := range pending {
+		call.Error = ErrServerShutdown
+		call.done()
+	}
+
+	client.handleServerRequest(notice)
+}
+
 // reconnect attempts to reconnect to the server when connection is lost
 func (client *Client) reconnect() {
+	client.mutex.Lock()
+	if client.reconnecting {
+		// input, qinput and ping loop may all find the connection lost
+		client.mutex.Unlock()
+		return
+	}
+	client.reconnecting = true
+	connType, addr := client.connType, client.addr
+	client.mutex.Unlock()
+
+	client.setState(StateReconnecting)
 	log.Println("Start to reconnect to server...")
-	for {
+	_, err := client.connectWithRetry(connType, addr, func() bool {
 		client.Close()
 		client.clearClient()
 
 		client.mutex.Lock()
-		autoReconnect := client.option.AutoReconnect
-		client.mutex.Unlock()
-
-		if !autoReconnect {
-			return
-		}
-
-		_, err := client.connectOnce(client.connType, client.addr)
-		if err == nil {
-			log.Println("Reconnected successfully.")
-			return
-		}
-		time.Sleep(client.option.ReconnectInterval)
+		defer client.mutex.Unlock()
+		return client.option.AutoReconnect
+	})
+	if err != nil {
+		log.Printf("VSOA: stop reconnecting: %v", err)
+		client.setState(StateClosed)
+		client.flushOffline(err)
+		return
 	}
+	log.Println("Reconnected successfully.")
+	client.resumeSession()
 }
 
 // Close calls the underlying connection's Close method. If the connection is already
@@ -598,6 +810,10 @@ func (client *Client) Close() error {
 
 	client.closing = true
 
+	if client.writer != nil {
+		client.writer.close()
+		client.writer = nil
+	}
 	if client.QConn != nil {
 		client.QConn.Close()
 	}
@@ -610,12 +826,20 @@ func (client *Client) Close() error {
 
 	client.mutex.Unlock()
 
+	// reconnect closes the lost connection too, the client is not closed
+	client.stateMu.Lock()
+	if client.state != StateReconnecting {
+		client.setStateLocked(StateClosed)
+	}
+	client.stateMu.Unlock()
+
 	return err
 }
 
 // Delete completely removes the client, closing all connections and cleaning up resources.
 // Unlike Close(), it ensures no reconnection attempts will be made.
 func (client *Client) Delete() error {
+	defer client.setState(StateClosed)
 	client.mutex.Lock()
 	defer client.mutex.Unlock()
 
@@ -637,6 +861,10 @@ func (client *Client) Delete() error {
 
 	// Close connections
 	var err error
+	if client.writer != nil {
+		client.writer.close()
+		client.writer = nil
+	}
 	if client.QConn != nil {
 		if cerr := client.QConn.Close(); cerr != nil {
 			err = cerr
@@ -650,7 +878,7 @@ func (client *Client) Delete() error {
 
 	// Clear all states
 	client.authed = false
-	client.pingTimeoutCount = 0
+	atomic.StoreInt32(&client.pingTimeoutCount, 0)
 	client.pending = nil
 	client.SubscribeList = nil
 	client.slotList = nil
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/acoinfo/vsoa/server"
+)
+
+// startTestServer serves s on a free local port and returns its address.
+func startTestServer(t *testing.T, s *server.Server) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	go s.Serve(addr)
+	for deadline := time.Now().Add(2 * time.Second); !s.IsStarted(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start in time")
+		}
+	}
+	return addr
+}
+
+// dropConn closes the normal channel of c under it, like a lost connection.
+func dropConn(c *Client) {
+	c.mutex.Lock()
+	conn := c.Conn
+	c.mutex.Unlock()
+
+	conn.Close()
+}
@@ -31,25 +31,33 @@ func (client *Client) SetPosition(address string) (err error) {
 // ServInfo Shack hand is needed cause VSOA protocol
 // TODO: add position logic.
 func (client *Client) Connect(vsoa_or_VSOA_URL, address_or_URL string) (ServerInfo string, err error) {
-	if !client.option.AutoReconnect {
+	if !client.autoReconnect() {
 		return client.connectOnce(vsoa_or_VSOA_URL, address_or_URL)
 	}
 
-	for {
-		serverInfo, err := client.connectOnce(vsoa_or_VSOA_URL, address_or_URL)
-		if err == nil {
-			return serverInfo, nil
-		}
-		time.Sleep(client.option.ReconnectInterval)
-	}
+	return client.connectWithRetry(vsoa_or_VSOA_URL, address_or_URL, nil)
 }
 
 func (client *Client) connectOnce(vsoa_or_VSOA_URL, address_or_URL string) (ServerInfo string, err error) {
+	failState := StateIdle
+	if client.State() == StateReconnecting {
+		failState = StateReconnecting
+	} else {
+		client.setState(StateConnecting)
+	}
+	defer func() {
+		if err != nil {
+			client.setState(failState)
+		}
+	}()
+
 	var conn net.Conn
 	var qconn *net.UDPConn
 
-	client.addr = address_or_URL
+	addr := address_or_URL
+	client.mutex.Lock()
 	client.connType = vsoa_or_VSOA_URL
+	client.mutex.Unlock()
 
 	// check client options is valid
 	if client.option.PingTurbo != 0 {
@@ -61,6 +69,11 @@ func (client *Client) connectOnce(vsoa_or_VSOA_URL, address_or_URL string) (Serv
 			return "", errors.New("PingInterval must be multiple of PingTurbo")
 		}
 	}
+	for _, name := range client.option.Codecs {
+		if protocol.GetCodec(name) == nil {
+			return "", ErrUnsupportedCodec
+		}
+	}
 
 	switch vsoa_or_VSOA_URL {
 	case "VSOA_URL":
@@ -84,34 +97,55 @@ func (client *Client) connectOnce(vsoa_or_VSOA_URL, address_or_URL string) (Serv
 			}
 		}
 
-		client.addr = p.IP + ":" + strconv.Itoa(p.Port)
-		println("client.addr", client.addr)
+		addr = p.IP + ":" + strconv.Itoa(p.Port)
+		println("client.addr", addr)
 		fallthrough
 	default:
-		conn, err = newDirectConn(client, client.addr)
+		client.mutex.Lock()
+		client.addr = addr
+		client.mutex.Unlock()
+
+		conn, err = newDirectConn(client, addr)
 
 		if err == nil && conn != nil {
+			if err = client.setupQuickCipher(conn); err != nil {
+				conn.Close()
+				return "", err
+			}
+			r := bufio.NewReaderSize(conn, ReaderBuffsize)
+
+			client.mutex.Lock()
 			client.Conn = conn
-			client.r = bufio.NewReaderSize(conn, ReaderBuffsize)
+			client.r = r
+			if client.writer != nil {
+				client.writer.close()
+			}
+			client.writer = newConnWriter(conn)
+			client.mutex.Unlock()
 
 			// start reading and writing since connected
-			go client.input()
+			go client.input(conn, r)
 		} else {
 			return "", err
 		}
 
-		qconn, err = newQuickConn(client, client.addr)
+		qconn, err = newQuickConn(client, addr)
 		{
 			if err == nil && qconn != nil {
+				qr := bufio.NewReaderSize(newQuickReader(client, qconn), ReaderBuffsize)
+
+				client.mutex.Lock()
 				client.QConn = qconn
-				client.qr = bufio.NewReaderSize(qconn, ReaderBuffsize)
-				go client.qinput()
+				client.qr = qr
+				client.mutex.Unlock()
+				go client.qinput(qconn, qr)
 			} else {
 				return "", err
 			}
 		}
 	}
 
+	client.setState(StateHandshaking)
 	req := protocol.NewMessage()
 
 	reply, err := client.Call("", protocol.TypeServInfo, protocol.RpcMethodGet, req)
@@ -119,12 +153,34 @@ func (client *Client) connectOnce(vsoa_or_VSOA_URL, address_or_URL string) (Serv
 		return "", err
 	}
 
+	info := protocol.DecodeServInfoRes(reply.Param)
+	if client.getQuickCipher() != nil && !info.QuickAEAD {
+		return "", ErrQuickEncryption
+	}
+	codec := protocol.GetCodec(info.Codec)
+	if codec == nil {
+		return "", ErrUnsupportedCodec
+	}
+
 	client.mutex.Lock()
 	client.authed = true
 	// this is used for Quick channel
 	client.uid = protocol.GetClientUid(reply.Data)
+	client.quickSigner = nil
+	if info.QuickToken != nil {
+		client.quickSigner = protocol.NewQuickSigner(info.QuickToken)
+	}
+	client.codec = codec
 	client.mutex.Unlock()
 
+	if client.option.Qos != nil {
+		if _, err = client.QosSetup(*client.option.Qos); err != nil {
+			return "", err
+		}
+	}
+
+	client.setReady()
+
 	if client.option.PingInterval != 0 {
 		go client.pingLoop()
 	}
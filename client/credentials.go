@@ -0,0 +1,19 @@
+package client
+
+// Credentials are sent to the server in the ServInfo handshake.
+type Credentials struct {
+	Password string
+	Token    string // made by protocol.NewToken
+}
+
+// CredentialProvider returns the credentials of a ServInfo handshake.
+// It is called on every connect and reconnect, so a token can be refreshed
+// before it expires.
+type CredentialProvider func() (Credentials, error)
+
+// StaticCredentials returns a CredentialProvider that always returns cred.
+func StaticCredentials(cred Credentials) CredentialProvider {
+	return func() (Credentials, error) {
+		return cred, nil
+	}
+}
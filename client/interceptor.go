@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+)
+
+// Invoker sends call to server and waits for it to complete.
+// It returns call.Error, the reply is in call.Reply.
+type Invoker func(ctx context.Context, call *Call) error
+
+// Interceptor intercepts every outgoing call, like gRPC unary client interceptor.
+//
+// call carries the URL, the message type, the RPC method and the request
+// Param & Data, which can be changed before calling invoker.
+// After invoker returns, call.Reply and the returned error are the server reply.
+// invoker can be called more than once to retry the call.
+type Interceptor func(ctx context.Context, call *Call, invoker Invoker) error
+
+// Use appends interceptors to the client, the first one is the outermost.
+// Ping and Noop keepalive calls are not intercepted.
+func (client *Client) Use(interceptors ...Interceptor) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.interceptors = append(client.interceptors, interceptors...)
+}
+
+func (client *Client) interceptorList() []Interceptor {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.interceptors
+}
+
+// intercept runs call through interceptors, and completes it with the result.
+func (client *Client) intercept(ctx context.Context, call *Call, interceptors []Interceptor) {
+	invoker := client.invoke
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, call *Call) error {
+			return interceptor(ctx, call, next)
+		}
+	}
+
+	call.Error = invoker(ctx, call)
+	call.done()
+}
+
+// invoke is the last Invoker of interceptors, it really sends call.
+// Each invoke sends a new Call, so a given up try never touches call.
+func (client *Client) invoke(ctx context.Context, call *Call) error {
+	try := &Call{
+		URL:           call.URL,
+		VsoaType:      call.VsoaType,
+		ServiceMethod: call.ServiceMethod,
+		IsQuick:       call.IsQuick,
+		Data:          call.Data,
+		Param:         call.Param,
+		Reply:         call.Reply,
+		Done:          make(chan *Call, 1),
+
+		failFast:       call.failFast,
+		offlineTimeout: call.offlineTimeout,
+	}
+
+	client.send(try)
+
+	select {
+	case <-try.Done:
+		call.Reply = try.Reply
+		return try.Error
+	case <-ctx.Done():
+		client.cancelCall(try)
+		return ctx.Err()
+	}
+}
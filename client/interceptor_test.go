@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/acoinfo/vsoa/protocol"
+	"github.com/acoinfo/vsoa/server"
+)
+
+func TestInterceptors(t *testing.T) {
+	s := server.NewServer("test", server.Option{})
+	var tries atomic.Int32
+	s.On("/flaky", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		// fails the first two tries
+		if tries.Add(1) <= 2 {
+			res.SetStatusType(protocol.StatusNoResponding)
+			return
+		}
+		res.Param = req.Param
+	})
+	addr := startTestServer(t, s)
+	defer s.Close()
+
+	c := NewClient(Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	var calls []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, call *Call, invoker Invoker) error {
+			calls = append(calls, name)
+			err := invoker(ctx, call)
+			calls = append(calls, name+" done")
+			return err
+		}
+	}
+	retry := func(ctx context.Context, call *Call, invoker Invoker) (err error) {
+		for i := 0; i < 3; i++ {
+			calls = append(calls, "try")
+			if err = invoker(ctx, call); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+	c.Use(trace("outer"), retry)
+	c.Use(trace("inner"))
+
+	req := protocol.NewMessage()
+	req.Param = []byte(`"ok"`)
+	reply, err := c.Call("/flaky", protocol.TypeRPC, protocol.RpcMethodGet, req)
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if string(reply.Param) != `"ok"` {
+		t.Fatalf("reply: got %q", reply.Param)
+	}
+	if n := tries.Load(); n != 3 {
+		t.Fatalf("server got %d tries, want 3", n)
+	}
+
+	want := []string{"outer"}
+	for i := 0; i < 3; i++ {
+		want = append(want, "try", "inner", "inner done")
+	}
+	want = append(want, "outer done")
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls %v, want %v", calls, want)
+	}
+}
@@ -1,71 +1,48 @@
 package client
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"strings"
 
 	"github.com/acoinfo/vsoa/protocol"
 )
 
-func (client *Client) input() {
+func (client *Client) input(conn net.Conn, r *bufio.Reader) {
 	var err error
 
 	for err == nil {
 		res := protocol.NewMessage()
 
-		err = res.Decode(client.r)
+		err = res.Decode(r)
 		if err != nil {
 			break
 		}
 
 		// This is for normal channel publish
 		if res.MessageType() == protocol.TypePublish {
-			if act, ok := client.SubscribeList[string(res.URL)]; ok {
-				if act != nil {
-					act(res)
-				}
-				client.regulatorUpdator(res)
-				continue
-			}
-			if act, ok := client.SubscribeList[string(res.URL)+"/"]; ok {
-				if act != nil {
-					act(res)
-				}
-				client.regulatorUpdator(res)
-				continue
-			}
-			if act, ok := client.SubscribeList[string(res.URL[:len(res.URL)-1])]; ok && strings.HasSuffix(string(res.URL), "/") {
-				if act != nil {
-					act(res)
-				}
-				client.regulatorUpdator(res)
-				continue
-			}
-			routelen := 0
-			savedAct := func(m *protocol.Message) {}
-			for route, actor := range client.SubscribeList {
-				// Find one handler and send
-				if strings.HasSuffix(route, "/") &&
-					strings.HasPrefix(string(res.URL), route) {
-					if len(route) < routelen {
-						continue
-					} else {
-						routelen = len(route)
-						savedAct = actor
-					}
-				}
-			}
-			if savedAct != nil {
-				savedAct(res)
+			if act := client.publishHandler(string(res.URL)); act != nil {
+				act(res)
 			}
 			client.regulatorUpdator(res)
 			continue
 		}
 
+		// Server closes the connection after it
+		if protocol.IsShutdownNotice(res) {
+			client.serverShutdown(res)
+			continue
+		}
+
+		// Server has unpublished the URL
+		if !res.IsReply() && res.IsUnSubscribe() {
+			client.serverUnsubscribe(res)
+			continue
+		}
+
 		seq := res.SeqNo()
 		var call *Call
 		isServerMessage := (!res.IsReply())
@@ -79,9 +56,7 @@ func (client *Client) input() {
 		switch {
 		case call == nil:
 			if isServerMessage {
-				if client.ServerMessageChan != nil {
-					client.handleServerRequest(res)
-				}
+				client.handleServerRequest(res)
 				continue
 			}
 		case res.StatusType() != protocol.StatusSuccess:
@@ -106,14 +81,24 @@ func (client *Client) input() {
 
 	// Terminate pending calls.
 	// This is used for Subscribe in VSOA
-	if client.ServerMessageChan != nil {
+	if client.serverMessageChan() != nil {
 		req := protocol.NewMessage()
 		req.SetMessageType(protocol.TypePublish)
 		req.SetStatusType(protocol.StatusNoResponding)
 		client.handleServerRequest(req)
 	}
 
-	err = client.Close()
+	// Connection is lost if it is not closed by user, server or reconnect.
+	client.mutex.Lock()
+	lost := !client.closing && !client.shutdown && client.Conn == conn
+	autoReconnect := client.option.AutoReconnect
+	client.mutex.Unlock()
+
+	if lost && autoReconnect {
+		// not Closed, Close below is a part of reconnect
+		client.setState(StateReconnecting)
+	}
+	client.Close()
 
 	if e, ok := err.(*net.OpError); ok {
 		if e.Addr != nil || e.Err != nil {
@@ -123,24 +108,24 @@ func (client *Client) input() {
 		}
 
 	}
-	if err == io.EOF {
-		if client.closing {
-			err = ErrShutdown
-		} else {
-			// If server aggressive close the client conn.
-			if client.option.AutoReconnect {
-				go client.reconnect()
-			}
-		}
+	if !lost {
+		err = ErrShutdown
 	}
-	for _, call := range client.pending {
+	client.mutex.Lock()
+	pending := client.pending
+	client.pending = nil
+	client.mutex.Unlock()
+	for _, call := range pending {
 		call.Error = err
 		call.done()
 	}
 
-	if err != nil && !client.closing && !client.shutdown {
-		log.Printf("VSOA: client protocol error: %v", err)
-		if client.option.AutoReconnect {
+	if lost {
+		// If server aggressive close the client conn, err is io.EOF.
+		if err != io.EOF {
+			log.Printf("VSOA: client protocol error: %v", err)
+		}
+		if autoReconnect {
 			go client.reconnect()
 		}
 	}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+var (
+	ErrOfflineQueueFull = errors.New("offline queue is full")
+	ErrCallExpired      = errors.New("call expired in offline queue")
+)
+
+// DefaultOfflineCallTimeout is how long a call waits in the offline queue
+// if Option.OfflineCallTimeout is not set.
+const DefaultOfflineCallTimeout = 10 * time.Second
+
+// offlineCall is a call queued while client is reconnecting.
+type offlineCall struct {
+	call  *Call
+	timer *time.Timer // fails call with ErrCallExpired
+}
+
+type failFastKey struct{}
+type offlineTimeoutKey struct{}
+
+// WithFailFast returns a context making calls fail with ErrShutdown while
+// client is reconnecting, instead of waiting in the offline queue.
+func WithFailFast(ctx context.Context) context.Context {
+	return context.WithValue(ctx, failFastKey{}, true)
+}
+
+// WithOfflineTimeout returns a context setting how long calls can wait in
+// the offline queue, it replaces Option.OfflineCallTimeout.
+func WithOfflineTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, offlineTimeoutKey{}, timeout)
+}
+
+// setOfflineOptions sets the offline queue options of call given by ctx.
+func (call *Call) setOfflineOptions(ctx context.Context) {
+	call.failFast, _ = ctx.Value(failFastKey{}).(bool)
+	call.offlineTimeout, _ = ctx.Value(offlineTimeoutKey{}).(time.Duration)
+}
+
+// enqueueOffline queues a RPC or DATAGRAM call while client is reconnecting,
+// it returns false if call should be sent now.
+func (client *Client) enqueueOffline(call *Call) bool {
+	if client.option.OfflineQueueSize <= 0 || call.failFast {
+		return false
+	}
+	if call.VsoaType != protocol.TypeRPC && call.VsoaType != protocol.TypeDatagram {
+		return false
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	// State is Reconnecting before reconnect starts
+	if !client.reconnecting && client.State() != StateReconnecting {
+		return false
+	}
+
+	if len(client.offline) >= client.option.OfflineQueueSize {
+		call.Error = ErrOfflineQueueFull
+		call.done()
+		return true
+	}
+
+	timeout := call.offlineTimeout
+	if timeout <= 0 {
+		timeout = client.option.OfflineCallTimeout
+	}
+	oc := &offlineCall{call: call}
+	oc.timer = time.AfterFunc(timeout, func() { client.expireOffline(oc) })
+	client.offline = append(client.offline, oc)
+	return true
+}
+
+func (client *Client) expireOffline(oc *offlineCall) {
+	client.mutex.Lock()
+	found := false
+	for i, c := range client.offline {
+		if c == oc {
+			client.offline = append(client.offline[:i], client.offline[i+1:]...)
+			found = true
+			break
+		}
+	}
+	client.mutex.Unlock()
+
+	// setReady or flushOffline has taken it otherwise
+	if found {
+		oc.call.Error = ErrCallExpired
+		oc.call.done()
+	}
+}
+
+// setReady moves client to StateReady and ends reconnecting, both under
+// client.mutex, so no call is queued after the queue is taken. The queued
+// calls are sent on the new connection.
+func (client *Client) setReady() {
+	client.mutex.Lock()
+	client.setState(StateReady)
+	queued := client.takeOffline()
+	client.mutex.Unlock()
+
+	client.sendOffline(queued, nil)
+}
+
+// flushOffline ends reconnecting that has given up, the queued calls
+// fail with err.
+func (client *Client) flushOffline(err error) {
+	client.mutex.Lock()
+	queued := client.takeOffline()
+	client.mutex.Unlock()
+
+	client.sendOffline(queued, err)
+}
+
+// takeOffline ends reconnecting and returns the queued calls.
+// It is called with client.mutex held.
+func (client *Client) takeOffline() []*offlineCall {
+	queued := client.offline
+	client.offline = nil
+	client.reconnecting = false
+	return queued
+}
+
+// sendOffline sends the queued calls if err is nil, otherwise they fail with err.
+func (client *Client) sendOffline(queued []*offlineCall, err error) {
+	for _, oc := range queued {
+		oc.timer.Stop()
+		if err != nil {
+			oc.call.Error = err
+			oc.call.done()
+			continue
+		}
+		client.send(oc.call)
+	}
+}
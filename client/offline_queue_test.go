@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/acoinfo/vsoa/protocol"
+	"github.com/acoinfo/vsoa/server"
+)
+
+func TestOfflineQueue(t *testing.T) {
+	echo := func(req, res *protocol.Message) {
+		res.Param = req.Param
+	}
+	s := server.NewServer("test", server.Option{})
+	s.On("/echo", protocol.RpcMethodGet, echo)
+	addr := startTestServer(t, s)
+
+	c := NewClient(Option{
+		AutoReconnect:     true,
+		ReconnectInterval: 20 * time.Millisecond,
+		OfflineQueueSize:  4,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states := c.WatchState(ctx)
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Delete()
+
+	s.Close()
+	waitState(t, states, StateReconnecting)
+
+	req := protocol.NewMessage()
+	req.Param = []byte(`"queued"`)
+	if _, err := c.CallContext(WithFailFast(ctx), "/echo", protocol.TypeRPC, protocol.RpcMethodGet, req); err == nil {
+		t.Fatal("fail fast call succeeded while reconnecting")
+	}
+	if _, err := c.CallContext(WithOfflineTimeout(ctx, 10*time.Millisecond), "/echo", protocol.TypeRPC, protocol.RpcMethodGet, req); err != ErrCallExpired {
+		t.Fatalf("got %v, want ErrCallExpired", err)
+	}
+
+	replies := make(chan error, 1)
+	go func() {
+		reply, err := c.Call("/echo", protocol.TypeRPC, protocol.RpcMethodGet, req)
+		if err == nil && string(reply.Param) != `"queued"` {
+			t.Errorf("got reply %s", reply.Param)
+		}
+		replies <- err
+	}()
+
+	s = server.NewServer("test", server.Option{})
+	s.On("/echo", protocol.RpcMethodGet, echo)
+	go s.Serve(addr)
+	defer s.Close()
+
+	select {
+	case err := <-replies:
+		if err != nil {
+			t.Fatalf("queued call: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("queued call was not sent after reconnect")
+	}
+}
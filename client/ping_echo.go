@@ -33,15 +33,24 @@ func (client *Client) pingOnce() {
 	reply := protocol.NewMessage()
 	call := client.Go("", protocol.TypePingEcho, nil, req, reply, nil)
 
+	lost := false
 	select {
 	case <-call.Done:
 		if call.Error != nil {
 			atomic.AddInt32(&client.pingTimeoutCount, 1)
+			lost = true
 		} else {
 			atomic.StoreInt32(&client.pingTimeoutCount, 0)
 		}
 	case <-ctx.Done():
 		atomic.AddInt32(&client.pingTimeoutCount, 1)
+		lost = true
+	}
+
+	if lost {
+		client.setStateIf(StateReady, StateDegraded)
+	} else {
+		client.setStateIf(StateDegraded, StateReady)
 	}
 }
 
@@ -55,7 +64,7 @@ func (client *Client) pingEchoLoop() {
 				client.option.OnDisconnect(client)
 			}
 
-			if client.option.AutoReconnect {
+			if client.autoReconnect() {
 				go client.reconnect()
 			}
 			return
@@ -69,10 +78,10 @@ func (client *Client) pingTurboLoop() {
 	ticker := time.NewTicker(IntervalTime)
 
 	// If Server / Client close conn, kill the pingLoop
-	for client.pingTimeoutCount < client.option.PingLost {
+	for atomic.LoadInt32(&client.pingTimeoutCount) < client.option.PingLost {
 		<-ticker.C
 
-		if len(client.pending) == 0 {
+		if client.pendingCount() == 0 {
 			continue
 		}
 
@@ -110,6 +119,12 @@ func (client *Client) sendPingEcho(call *Call) {
 		return
 	}
 
+	if call.canceled {
+		// CallContext has given up this call
+		client.mutex.Unlock()
+		return
+	}
+
 	if client.pending == nil {
 		client.pending = make(map[uint32]*Call)
 	}
@@ -117,6 +132,7 @@ func (client *Client) sendPingEcho(call *Call) {
 	seq := client.seq
 	client.seq++
 	client.pending[seq] = call
+	call.seq = seq
 
 	req := protocol.NewMessage()
 	req.SetMessageType(protocol.TypePingEcho)
@@ -140,7 +156,7 @@ func (client *Client) sendPingEcho(call *Call) {
 	}
 	client.mutex.Unlock()
 
-	_, err = client.Conn.Write(tmp)
+	err = client.writeFrame(tmp)
 
 	// TODO: add ping fault logic
 	if err != nil {
@@ -153,7 +169,7 @@ func (client *Client) sendPingEcho(call *Call) {
 
 		}
 		client.mutex.Lock()
-		client.pingTimeoutCount++
+		atomic.AddInt32(&client.pingTimeoutCount, 1)
 		call = client.pending[seq]
 		delete(client.pending, seq)
 		client.mutex.Unlock()
@@ -172,17 +188,18 @@ func (client *Client) sendNoop(call *Call) {
 	// no reply. call is complete.
 	defer call.done()
 
-	if client.shutdown || client.closing {
-		call.Error = ErrShutdown
-		return
-	}
-
 	if client.option.PingInterval < client.option.PingTimeout || client.option.PingLost == 0 {
 		call.Error = ErrPingEcho
 		return
 	}
 
 	client.mutex.Lock()
+	if client.shutdown || client.closing {
+		call.Error = ErrShutdown
+		client.mutex.Unlock()
+		return
+	}
+
 	var tmpNoseq uint32
 	if client.noseq == 0 {
 		tmpNoseq = 1
@@ -211,7 +228,7 @@ func (client *Client) sendNoop(call *Call) {
 		return
 	}
 
-	_, err = client.Conn.Write(tmp)
+	err = client.writeFrame(tmp)
 
 	if err != nil {
 		if e, ok := err.(*net.OpError); ok {
@@ -223,7 +240,7 @@ func (client *Client) sendNoop(call *Call) {
 
 		}
 		client.mutex.Lock()
-		client.pingTimeoutCount++
+		atomic.AddInt32(&client.pingTimeoutCount, 1)
 		client.mutex.Unlock()
 		if call != nil {
 			call.Error = err
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+var (
+	ErrPoolClosed = errors.New("pool is closed")
+)
+
+// Balancer picks the client of a Pool for a call.
+// clients is never empty.
+type Balancer interface {
+	Pick(clients []*Client) *Client
+}
+
+// RoundRobin picks the clients in turn.
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (b *RoundRobin) Pick(clients []*Client) *Client {
+	return clients[(b.next.Add(1)-1)%uint64(len(clients))]
+}
+
+// LeastPending picks the client having the fewest calls waiting for reply.
+type LeastPending struct{}
+
+func (LeastPending) Pick(clients []*Client) *Client {
+	best, least := clients[0], clients[0].pendingCount()
+	for _, c := range clients[1:] {
+		if n := c.pendingCount(); n < least {
+			best, least = c, n
+		}
+	}
+	return best
+}
+
+// Pool keeps N connections to one server and spreads calls over them.
+//
+// Subscriptions are pinned to the first connection, so every publish is
+// received once.
+type Pool struct {
+	clients  []*Client
+	balancer Balancer
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewPool returns a pool of size clients with the option.
+// balancer nil means RoundRobin.
+func NewPool(size int, option Option, balancer Balancer) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	if balancer == nil {
+		balancer = new(RoundRobin)
+	}
+
+	p := &Pool{balancer: balancer}
+	for i := 0; i < size; i++ {
+		p.clients = append(p.clients, NewClient(option))
+	}
+	return p
+}
+
+// Connect connects all clients of the pool like Client.Connect.
+// If one fails, the pool is closed like Close, so no client reconnects.
+func (p *Pool) Connect(vsoa_or_VSOA_URL, address_or_URL string) (ServerInfo string, err error) {
+	for _, c := range p.clients {
+		if ServerInfo, err = c.Connect(vsoa_or_VSOA_URL, address_or_URL); err != nil {
+			p.Close()
+			return "", err
+		}
+	}
+	return ServerInfo, nil
+}
+
+// Clients returns the clients of the pool.
+func (p *Pool) Clients() []*Client {
+	return p.clients
+}
+
+// pick returns the client for a call, connected clients are preferred.
+func (p *Pool) pick() (*Client, error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil, ErrPoolClosed
+	}
+
+	ready := make([]*Client, 0, len(p.clients))
+	for _, c := range p.clients {
+		if st := c.State(); st == StateReady || st == StateDegraded {
+			ready = append(ready, c)
+		}
+	}
+	if len(ready) == 0 {
+		// calls may wait in the offline queue of reconnecting clients
+		ready = p.clients
+	}
+	return p.balancer.Pick(ready), nil
+}
+
+// Go invokes the function asynchronously on one client, like Client.Go.
+func (p *Pool) Go(URL string, mt protocol.MessageType, flags any, req *protocol.Message, reply *protocol.Message, done chan *Call) (*Call, error) {
+	c, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+	return c.Go(URL, mt, flags, req, reply, done), nil
+}
+
+// Call invokes the named function on one client, like Client.Call.
+func (p *Pool) Call(URL string, mt protocol.MessageType, flags any, req *protocol.Message) (*protocol.Message, error) {
+	c, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+	return c.Call(URL, mt, flags, req)
+}
+
+// CallContext invokes the named function on one client, like Client.CallContext.
+func (p *Pool) CallContext(ctx context.Context, URL string, mt protocol.MessageType, flags any, req *protocol.Message) (*protocol.Message, error) {
+	c, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+	return c.CallContext(ctx, URL, mt, flags, req)
+}
+
+// Subscribe subscribes URL on the first client of the pool only, so every
+// publish is received once. Publishes are not spread over the pool, and
+// they stop while the first client is reconnecting; it subscribes again
+// after reconnect.
+func (p *Pool) Subscribe(URL string, onPublish func(m *protocol.Message)) error {
+	return p.clients[0].Subscribe(URL, onPublish)
+}
+
+// UnSubscribe unsubscribes URL on the first client of the pool.
+func (p *Pool) UnSubscribe(URL string) error {
+	return p.clients[0].UnSubscribe(URL)
+}
+
+// Close closes all clients of the pool, they will not reconnect.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	var err error
+	for _, c := range p.clients {
+		if cerr := c.Delete(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
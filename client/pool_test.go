@@ -0,0 +1,88 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/acoinfo/vsoa/protocol"
+	"github.com/acoinfo/vsoa/server"
+)
+
+func TestClientPool(t *testing.T) {
+	s := server.NewServer("test", server.Option{AutoAuth: true})
+	s.On("/uid", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		res.Param, _ = json.Marshal(s.Context(req).ClientUid)
+	})
+	s.RegisterPublishURL("/pub")
+	addr := startTestServer(t, s)
+	defer s.Close()
+
+	p := NewPool(3, Option{}, nil)
+	if _, err := p.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer p.Close()
+	if n := s.Count(); n != 3 {
+		t.Fatalf("server has %d clients, want 3", n)
+	}
+
+	calls := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		reply, err := p.Call("/uid", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage())
+		if err != nil {
+			t.Fatalf("call: %v", err)
+		}
+		calls[string(reply.Param)]++
+	}
+	if len(calls) != 3 {
+		t.Fatalf("calls by client: %v, want 2 calls on each of 3 clients", calls)
+	}
+	for uid, n := range calls {
+		if n != 2 {
+			t.Fatalf("client %s got %d calls, want 2", uid, n)
+		}
+	}
+
+	published := make(chan struct{}, 3)
+	if err := p.Subscribe("/pub", func(m *protocol.Message) {
+		published <- struct{}{}
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	s.PublishMessage("/pub", nil, nil)
+
+	select {
+	case <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("pool did not get publish")
+	}
+	select {
+	case <-published:
+		t.Fatal("publish is received more than once")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestClientPoolConnectFails(t *testing.T) {
+	// nothing listens on the address of a closed server
+	s := server.NewServer("test", server.Option{})
+	addr := startTestServer(t, s)
+	s.Close()
+
+	p := NewPool(2, Option{
+		AutoReconnect:   true,
+		ReconnectPolicy: &ReconnectPolicy{MaxAttempts: 1},
+	}, nil)
+	if _, err := p.Connect("vsoa", addr); err == nil {
+		t.Fatal("connect to closed server succeeded")
+	}
+	for i, c := range p.Clients() {
+		if c.autoReconnect() {
+			t.Fatalf("client %d can still reconnect", i)
+		}
+	}
+	if _, err := p.Call("/a", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage()); err != ErrPoolClosed {
+		t.Fatalf("call after failed connect: got %v, want ErrPoolClosed", err)
+	}
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// QosSetup asks the server to apply QoS settings to this connection.
+// It returns the settings accepted by the server, a rejected setup
+// returns the server status as error.
+func (client *Client) QosSetup(qos protocol.QosSetupParam) (protocol.QosSetupParam, error) {
+	if err := qos.Validate(); err != nil {
+		return qos, err
+	}
+
+	req := protocol.NewMessage()
+	qos.NewMessage(req)
+
+	reply, err := client.Call("", protocol.TypeQosSetup, nil, req)
+	if err != nil {
+		return qos, err
+	}
+
+	return protocol.DecodeQosSetup(reply.Param)
+}
+
+// Client send QosSetup message
+// Similar to RPC call
+// Internal use. User should call QosSetup
+func (client *Client) sendQosSetup(call *Call) {
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.TypeQosSetup)
+
+	req.Param = *call.Param
+	req.Data = call.Data
+
+	client.sendPending(call, req)
+}
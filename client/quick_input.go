@@ -1,71 +1,46 @@
 package client
 
 import (
-	"strings"
+	"bufio"
+	"net"
 
 	"github.com/acoinfo/vsoa/protocol"
 )
 
-// quick channel will only receive server's publish in Quick channel
-func (client *Client) qinput() {
+// quick channel will only receive server's publish & datagram in Quick channel
+func (client *Client) qinput(qconn *net.UDPConn, qr *bufio.Reader) {
 	var err error
 
 	for err == nil {
 		res := protocol.NewMessage()
 
-		err = res.Decode(client.qr)
+		err = res.Decode(qr)
 		if err != nil {
 			break
 		}
 
 		switch {
 		case res.MessageType() == protocol.TypePublish:
-			if act, ok := client.SubscribeList[string(res.URL)]; ok {
-				if act != nil {
-					act(res)
-				}
-				client.regulatorUpdator(res)
-				continue
-			}
-			if act, ok := client.SubscribeList[string(res.URL)+"/"]; ok {
-				if act != nil {
-					act(res)
-				}
-				client.regulatorUpdator(res)
-				continue
-			}
-			if act, ok := client.SubscribeList[string(res.URL[:len(res.URL)-1])]; ok && strings.HasSuffix(string(res.URL), "/") {
-				if act != nil {
-					act(res)
-				}
-				client.regulatorUpdator(res)
-				continue
-			}
-			routelen := 0
-			savedAct := func(m *protocol.Message) {}
-			for route, actor := range client.SubscribeList {
-				// Find one handler and send
-				if strings.HasSuffix(route, "/") &&
-					strings.HasPrefix(string(res.URL), route) {
-					if len(route) < routelen {
-						continue
-					} else {
-						routelen = len(route)
-						savedAct = actor
-					}
-				}
-			}
-			if savedAct != nil {
-				savedAct(res)
+			if act := client.publishHandler(string(res.URL)); act != nil {
+				act(res)
 			}
 			client.regulatorUpdator(res)
 			continue
+		case res.MessageType() == protocol.TypeDatagram:
+			// Server DatagramTo this client
+			client.handleServerRequest(res)
+			continue
 		default:
 			continue
 		}
 	}
 
-	if err != nil && !client.closing && !client.shutdown && client.option.AutoReconnect {
+	client.mutex.Lock()
+	lost := !client.closing && !client.shutdown && client.QConn == qconn
+	autoReconnect := client.option.AutoReconnect
+	client.mutex.Unlock()
+
+	if err != nil && lost && autoReconnect {
 		go client.reconnect()
 	}
 }
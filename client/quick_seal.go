@@ -0,0 +1,67 @@
+package client
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// quickReader reads the quick channel datagrams, sealed ones are opened
+// and forged or replayed ones are dropped.
+type quickReader struct {
+	client *Client
+	conn   *net.UDPConn
+	buf    []byte
+}
+
+func newQuickReader(client *Client, conn *net.UDPConn) *quickReader {
+	return &quickReader{client: client, conn: conn, buf: make([]byte, ReaderBuffsize)}
+}
+
+func (r *quickReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.conn.Read(r.buf)
+		if err != nil {
+			return 0, err
+		}
+
+		qc := r.client.getQuickCipher()
+		if qc == nil {
+			return copy(p, r.buf[:n]), nil
+		}
+		frame, err := qc.Open(r.buf[:n])
+		if err != nil {
+			continue
+		}
+		return copy(p, frame), nil
+	}
+}
+
+// setupQuickCipher derives the quick channel keys from the TLS conn
+// if Option.QuickEncryption is set.
+func (client *Client) setupQuickCipher(conn net.Conn) error {
+	var qc *protocol.QuickCipher
+	if client.option.QuickEncryption {
+		tlsConn, ok := conn.(*tls.Conn)
+		if !ok {
+			return ErrQuickEncryption
+		}
+		var err error
+		if qc, err = protocol.NewQuickCipher(tlsConn, false); err != nil {
+			return err
+		}
+	}
+
+	client.mutex.Lock()
+	client.quickCipher = qc
+	client.mutex.Unlock()
+	return nil
+}
+
+func (client *Client) getQuickCipher() *protocol.QuickCipher {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.quickCipher
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// DefaultMaxBackoff is the max reconnect backoff if ReconnectPolicy.MaxBackoff is not set.
+const DefaultMaxBackoff = 30 * time.Second
+
+// ReconnectPolicy controls the retries of Connect and automatic reconnect
+// when Option.AutoReconnect is set.
+//
+// The backoff before retry n is InitialBackoff * Multiplier^(n-1), limited
+// by MaxBackoff, and changed randomly by up to Jitter of itself, so clients
+// losing the same server do not retry in lockstep.
+type ReconnectPolicy struct {
+	InitialBackoff time.Duration // default Option.ReconnectInterval
+	MaxBackoff     time.Duration // default DefaultMaxBackoff
+	Multiplier     float64       // default 2
+	Jitter         float64       // 0 to 1, default 0
+	MaxAttempts    int           // 0 means retry forever
+
+	// OnRetry is called after a failed attempt, before waiting backoff.
+	OnRetry func(c *Client, attempt int, err error, backoff time.Duration)
+	// OnGiveUp is called when MaxAttempts attempts have failed.
+	OnGiveUp func(c *Client, err error)
+}
+
+// reconnectPolicy returns Option.ReconnectPolicy with defaults filled. Without
+// it, client retries forever every Option.ReconnectInterval.
+func (client *Client) reconnectPolicy() ReconnectPolicy {
+	if client.option.ReconnectPolicy == nil {
+		return ReconnectPolicy{
+			InitialBackoff: client.option.ReconnectInterval,
+			MaxBackoff:     client.option.ReconnectInterval,
+			Multiplier:     1,
+		}
+	}
+
+	p := *client.option.ReconnectPolicy
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = client.option.ReconnectInterval
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultMaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	p.Jitter = min(max(p.Jitter, 0), 1)
+	return p
+}
+
+// backoff returns the wait time after the failed attempt.
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	d = min(d, float64(p.MaxBackoff))
+	if p.Jitter > 0 {
+		d += (rand.Float64()*2 - 1) * p.Jitter * d
+	}
+	return time.Duration(d)
+}
+
+// connectWithRetry calls connectOnce until it succeeds or the policy gives up.
+// prepare is called before every attempt, retry stops if it returns false.
+func (client *Client) connectWithRetry(vsoa_or_VSOA_URL, address_or_URL string, prepare func() bool) (string, error) {
+	policy := client.reconnectPolicy()
+
+	for attempt := 1; ; attempt++ {
+		if prepare != nil && !prepare() {
+			return "", ErrShutdown
+		}
+
+		serverInfo, err := client.connectOnce(vsoa_or_VSOA_URL, address_or_URL)
+		if err == nil {
+			return serverInfo, nil
+		}
+
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			if policy.OnGiveUp != nil {
+				policy.OnGiveUp(client, err)
+			}
+			return "", err
+		}
+
+		backoff := policy.backoff(attempt)
+		if policy.OnRetry != nil {
+			policy.OnRetry(client, attempt, err, backoff)
+		}
+		time.Sleep(backoff)
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/acoinfo/vsoa/protocol"
+	"github.com/acoinfo/vsoa/server"
+)
+
+func TestResubscribeAfterReconnect(t *testing.T) {
+	s := server.NewServer("test", server.Option{AutoAuth: true})
+	s.RegisterPublishURL("/pub")
+	addr := startTestServer(t, s)
+	defer s.Close()
+
+	reconnected := make(chan ReconnectEvent, 1)
+	c := NewClient(Option{
+		AutoReconnect:     true,
+		ReconnectInterval: 50 * time.Millisecond,
+		OnReconnect: func(c *Client, ev ReconnectEvent) {
+			reconnected <- ev
+		},
+	})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Delete()
+
+	published := make(chan string, 1)
+	if err := c.Subscribe("/pub", func(m *protocol.Message) {
+		published <- string(m.Data)
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	dropConn(c)
+
+	select {
+	case ev := <-reconnected:
+		if len(ev.Resubscribed) != 1 || ev.Resubscribed[0] != "/pub" || ev.Failed != nil {
+			t.Fatalf("got reconnect event %+v", ev)
+		}
+		if ev.Uid != c.GetUid() {
+			t.Fatalf("event uid %d, client uid %d", ev.Uid, c.GetUid())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+
+	if err := s.PublishMessage("/pub", nil, []byte("after")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case data := <-published:
+		if data != "after" {
+			t.Fatalf("got publish %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscriber did not get publish after reconnect")
+	}
+}
+
+func TestReconnectPolicyGivesUp(t *testing.T) {
+	// nothing listens on the address of a closed server
+	s := server.NewServer("test", server.Option{})
+	addr := startTestServer(t, s)
+	s.Close()
+
+	var backoffs []time.Duration
+	var gaveUp error
+	c := NewClient(Option{
+		AutoReconnect: true,
+		ReconnectPolicy: &ReconnectPolicy{
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     15 * time.Millisecond,
+			MaxAttempts:    4,
+			OnRetry: func(c *Client, attempt int, err error, backoff time.Duration) {
+				backoffs = append(backoffs, backoff)
+			},
+			OnGiveUp: func(c *Client, err error) {
+				gaveUp = err
+			},
+		},
+	})
+
+	_, err := c.Connect("vsoa", addr)
+	if err == nil || gaveUp != err {
+		t.Fatalf("connect: got %v, OnGiveUp got %v", err, gaveUp)
+	}
+	want := []time.Duration{10 * time.Millisecond, 15 * time.Millisecond, 15 * time.Millisecond}
+	if len(backoffs) != len(want) {
+		t.Fatalf("got backoffs %v, want %v", backoffs, want)
+	}
+	for i := range want {
+		if backoffs[i] != want[i] {
+			t.Fatalf("got backoffs %v, want %v", backoffs, want)
+		}
+	}
+}
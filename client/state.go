@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+)
+
+// State is the connection state of a Client.
+type State int32
+
+const (
+	StateIdle         State = iota // not connected yet
+	StateConnecting                // dialing the server
+	StateHandshaking               // ServInfo handshake is running
+	StateReady                     // connected and authenticated
+	StateDegraded                  // connected, but pings are lost
+	StateReconnecting              // connection lost, reconnecting
+	StateClosed                    // closed, or reconnect has given up
+)
+
+var stateText = [...]string{
+	StateIdle:         "Idle",
+	StateConnecting:   "Connecting",
+	StateHandshaking:  "Handshaking",
+	StateReady:        "Ready",
+	StateDegraded:     "Degraded",
+	StateReconnecting: "Reconnecting",
+	StateClosed:       "Closed",
+}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateText) {
+		return "Unknown"
+	}
+	return stateText[s]
+}
+
+// State returns the connection state of client.
+func (client *Client) State() State {
+	client.stateMu.Lock()
+	defer client.stateMu.Unlock()
+
+	return client.state
+}
+
+// WatchState returns a channel receiving the current state and every state
+// change after it, until ctx is done and the channel is closed. A slow
+// receiver gets the latest state, the states between are skipped.
+func (client *Client) WatchState(ctx context.Context) <-chan State {
+	ch := make(chan State, 1)
+
+	client.stateMu.Lock()
+	if client.watchers == nil {
+		client.watchers = make(map[chan State]struct{})
+	}
+	client.watchers[ch] = struct{}{}
+	ch <- client.state
+	client.stateMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		client.stateMu.Lock()
+		delete(client.watchers, ch)
+		close(ch)
+		client.stateMu.Unlock()
+	}()
+
+	return ch
+}
+
+func (client *Client) setState(s State) {
+	client.stateMu.Lock()
+	defer client.stateMu.Unlock()
+
+	client.setStateLocked(s)
+}
+
+// setStateIf changes the state to s only if it is from.
+func (client *Client) setStateIf(from, s State) {
+	client.stateMu.Lock()
+	defer client.stateMu.Unlock()
+
+	if client.state == from {
+		client.setStateLocked(s)
+	}
+}
+
+func (client *Client) setStateLocked(s State) {
+	if client.state == s {
+		return
+	}
+	client.state = s
+
+	for ch := range client.watchers {
+		// keep only the latest state for slow watchers
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- s
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/acoinfo/vsoa/server"
+)
+
+func waitState(t *testing.T, states <-chan State, want State) {
+	t.Helper()
+
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case st := <-states:
+			if st == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("client did not get state %v", want)
+		}
+	}
+}
+
+func TestClientStateChanges(t *testing.T) {
+	s := server.NewServer("test", server.Option{})
+	addr := startTestServer(t, s)
+	defer s.Close()
+
+	c := NewClient(Option{
+		AutoReconnect:     true,
+		ReconnectInterval: 50 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states := c.WatchState(ctx)
+	waitState(t, states, StateIdle)
+
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if st := c.State(); st != StateReady {
+		t.Fatalf("got state %v after connect, want Ready", st)
+	}
+	waitState(t, states, StateReady)
+
+	dropConn(c)
+	waitState(t, states, StateReconnecting)
+	waitState(t, states, StateReady)
+
+	c.Delete()
+	waitState(t, states, StateClosed)
+
+	cancel()
+	for range states {
+		// drained until closed
+	}
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -30,7 +31,13 @@ func (client *Client) NewClientStream(tunid uint16) (cs *ClientStream, err error
 
 	var conn net.Conn
 
-	conn, err = net.DialTimeout("tcp", address, client.option.ConnectTimeout)
+	if client.option.TLSConfig != nil {
+		// Stream uses TLS with the server
+		dialer := &net.Dialer{Timeout: client.option.ConnectTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, client.option.TLSConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, client.option.ConnectTimeout)
+	}
 
 	if err != nil {
 		return nil, err
@@ -19,10 +19,6 @@ func (client *Client) Subscribe(URL string, onPublish func(m *protocol.Message))
 		return err
 	}
 
-	if client.SubscribeList == nil {
-		client.SubscribeList = make(map[string]func(*protocol.Message))
-	}
-
 	req := protocol.NewMessage()
 	// Subscribe call the server will return nothing in Param & Data
 	_, err = client.Call(URL, protocol.TypeSubscribe, nil, req)
@@ -32,6 +28,9 @@ func (client *Client) Subscribe(URL string, onPublish func(m *protocol.Message))
 	} else {
 		client.mutex.Lock()
 		defer client.mutex.Unlock()
+		if client.SubscribeList == nil {
+			client.SubscribeList = make(map[string]func(*protocol.Message))
+		}
 		if onPublish != nil {
 			client.SubscribeList[URL] = onPublish
 		} else {
@@ -41,6 +40,57 @@ func (client *Client) Subscribe(URL string, onPublish func(m *protocol.Message))
 	return err
 }
 
+// serverUnsubscribe removes the URL unpublished by server from the subscribe
+// and slot lists. The message goes to ServerMessageChan.
+func (client *Client) serverUnsubscribe(msg *protocol.Message) {
+	URL := string(msg.URL)
+	// Server may keep the subscription with or without trailing slash
+	other := URL + "/"
+	if strings.HasSuffix(URL, "/") {
+		other = URL[:len(URL)-1]
+	}
+
+	client.mutex.Lock()
+	delete(client.SubscribeList, URL)
+	delete(client.SubscribeList, other)
+	delete(client.slotList, URL)
+	delete(client.slotList, other)
+	client.mutex.Unlock()
+
+	client.handleServerRequest(msg)
+}
+
+// publishHandler returns the SubscribeList callback of a publish to URL.
+// Exact URL wins, otherwise the longest subscribed parent URL is used.
+func (client *Client) publishHandler(URL string) func(m *protocol.Message) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if act, ok := client.SubscribeList[URL]; ok {
+		return act
+	}
+	if act, ok := client.SubscribeList[URL+"/"]; ok {
+		return act
+	}
+	if strings.HasSuffix(URL, "/") {
+		if act, ok := client.SubscribeList[URL[:len(URL)-1]]; ok {
+			return act
+		}
+	}
+
+	routelen := 0
+	var savedAct func(m *protocol.Message)
+	for route, actor := range client.SubscribeList {
+		// Find one handler and send
+		if strings.HasSuffix(route, "/") &&
+			strings.HasPrefix(URL, route) && len(route) >= routelen {
+			routelen = len(route)
+			savedAct = actor
+		}
+	}
+	return savedAct
+}
+
 // UnSubscribe server URL;
 // free callback to the URL with father URL
 func (client *Client) UnSubscribe(URL string) error {
@@ -55,24 +105,24 @@ func (client *Client) UnSubscribe(URL string) error {
 		log.Println(err)
 	}
 
+	client.mutex.Lock()
 	if client.SubscribeList == nil {
 		// Already unSubscribe
+		client.mutex.Unlock()
 		return nil
 	}
 
 	if _, ok := client.SubscribeList[URL]; ok {
-		client.mutex.Lock()
 		delete(client.SubscribeList, URL)
-		client.mutex.Unlock()
 	} else if strings.HasSuffix(URL, "/") {
 		if client.SubscribeList[URL[:len(URL)-1]] != nil {
-			client.mutex.Lock()
 			delete(client.SubscribeList, URL[:len(URL)-1])
-			client.mutex.Unlock()
 		}
 		// Already unSubscribe
+		client.mutex.Unlock()
 		return nil
 	}
+	client.mutex.Unlock()
 
 	req := protocol.NewMessage()
 	// Subscribe call the server will return nothing in Param & Data
@@ -84,6 +134,46 @@ func (client *Client) UnSubscribe(URL string) error {
 	return err
 }
 
+// ReconnectEvent tells the result of an automatic reconnect.
+type ReconnectEvent struct {
+	Uid          uint32           // new client UID given by server
+	Resubscribed []string         // URLs subscribed again
+	Failed       map[string]error // URLs server refused, their callbacks are kept
+}
+
+// resumeSession subscribes the SubscribeList URLs to the new server session,
+// clears the slot data of the old session and calls Option.OnReconnect.
+func (client *Client) resumeSession() {
+	client.mutex.Lock()
+	URLs := make([]string, 0, len(client.SubscribeList))
+	for URL := range client.SubscribeList {
+		URLs = append(URLs, URL)
+	}
+	for _, cs := range client.slotList {
+		cs.hasData = false
+		cs.raw = nil
+	}
+	client.mutex.Unlock()
+
+	ev := ReconnectEvent{Uid: client.GetUid()}
+	for _, URL := range URLs {
+		_, err := client.Call(URL, protocol.TypeSubscribe, nil, protocol.NewMessage())
+		if err != nil {
+			if ev.Failed == nil {
+				ev.Failed = make(map[string]error)
+			}
+			ev.Failed[URL] = err
+			log.Printf("VSOA: resubscribe %s: %v", URL, err)
+			continue
+		}
+		ev.Resubscribed = append(ev.Resubscribed, URL)
+	}
+
+	if client.option.OnReconnect != nil {
+		client.option.OnReconnect(client, ev)
+	}
+}
+
 func defaultOnPublish(m *protocol.Message) {
 	log.Println("URL:", m.URL, "Param:", (m.Param), "Data:", (m.Data))
 }
@@ -101,6 +191,12 @@ func (client *Client) sendSubscribe(call *Call, isSubscribe bool) {
 		return
 	}
 
+	if call.canceled {
+		// CallContext has given up this call
+		client.mutex.Unlock()
+		return
+	}
+
 	if client.pending == nil {
 		client.pending = make(map[uint32]*Call)
 	}
@@ -108,6 +204,7 @@ func (client *Client) sendSubscribe(call *Call, isSubscribe bool) {
 	seq := client.seq
 	client.seq++
 	client.pending[seq] = call
+	call.seq = seq
 
 	req := protocol.NewMessage()
 	if isSubscribe {
@@ -135,7 +232,7 @@ func (client *Client) sendSubscribe(call *Call, isSubscribe bool) {
 	}
 	client.mutex.Unlock()
 
-	_, err = client.Conn.Write(tmp)
+	err = client.writeFrame(tmp)
 
 	if err != nil {
 		if e, ok := err.(*net.OpError); ok {
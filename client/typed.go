@@ -0,0 +1,40 @@
+package client
+
+import (
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// Codec returns the Param codec of typed calls, selected by server in the
+// last ServInfo handshake. It is JSON before connected.
+func (client *Client) Codec() protocol.Codec {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.codec == nil {
+		return protocol.GetCodec(protocol.CodecJSON)
+	}
+	return client.codec
+}
+
+// CallTyped calls RPC URL with req encoded to Param, and decodes the reply
+// Param to Resp, both with the codec negotiated with server.
+func CallTyped[Req, Resp any](client *Client, URL string, method protocol.RpcMessageType, req Req) (Resp, error) {
+	var resp Resp
+	codec := client.Codec()
+
+	param, err := codec.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	msg := protocol.NewMessage()
+	msg.Param = param
+
+	reply, err := client.Call(URL, protocol.TypeRPC, method, msg)
+	if err != nil {
+		return resp, err
+	}
+	if len(reply.Param) > 0 {
+		err = codec.Unmarshal(reply.Param, &resp)
+	}
+	return resp, err
+}
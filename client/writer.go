@@ -0,0 +1,110 @@
+package client
+
+import (
+	"net"
+	"sync"
+)
+
+// maxWriteBatch is the max number of frames coalesced into one write.
+const maxWriteBatch = 64
+
+// writeReq is one encoded frame waiting for the writer.
+type writeReq struct {
+	frame []byte
+	errc  chan error
+}
+
+// connWriter serializes all normal channel writes of one connection, so
+// frames of concurrent calls never interleave on the stream.
+// Frames queued while a write is in flight are coalesced into one writev.
+type connWriter struct {
+	conn      net.Conn
+	reqs      chan writeReq
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnWriter(conn net.Conn) *connWriter {
+	w := &connWriter{
+		conn: conn,
+		reqs: make(chan writeReq, maxWriteBatch),
+		done: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// write queues frame and waits until it is written.
+func (w *connWriter) write(frame []byte) error {
+	req := writeReq{frame: frame, errc: make(chan error, 1)}
+
+	select {
+	case w.reqs <- req:
+	case <-w.done:
+		return ErrShutdown
+	}
+
+	select {
+	case err := <-req.errc:
+		return err
+	case <-w.done:
+		return ErrShutdown
+	}
+}
+
+func (w *connWriter) run() {
+	batch := make([]writeReq, 0, maxWriteBatch)
+	bufs := make(net.Buffers, 0, maxWriteBatch)
+
+	for {
+		select {
+		case req := <-w.reqs:
+			batch = append(batch[:0], req)
+		case <-w.done:
+			return
+		}
+
+		// take all frames already queued
+	COLLECT:
+		for len(batch) < maxWriteBatch {
+			select {
+			case req := <-w.reqs:
+				batch = append(batch, req)
+			default:
+				break COLLECT
+			}
+		}
+
+		bufs = bufs[:0]
+		for _, req := range batch {
+			bufs = append(bufs, req.frame)
+		}
+		// WriteTo consumes its receiver, keep bufs for reuse
+		out := bufs
+		_, err := out.WriteTo(w.conn)
+
+		for i := range batch {
+			batch[i].errc <- err
+			batch[i] = writeReq{}
+		}
+		clear(bufs)
+	}
+}
+
+// close stops the writer, waiting writes return ErrShutdown.
+func (w *connWriter) close() {
+	w.closeOnce.Do(func() { close(w.done) })
+}
+
+// writeFrame writes an encoded frame to the normal channel through the
+// connection writer.
+func (client *Client) writeFrame(frame []byte) error {
+	client.mutex.Lock()
+	w := client.writer
+	client.mutex.Unlock()
+
+	if w == nil {
+		return ErrShutdown
+	}
+	return w.write(frame)
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/acoinfo/vsoa/protocol"
+	"github.com/acoinfo/vsoa/server"
+)
+
+func TestConcurrentCalls(t *testing.T) {
+	s := server.NewServer("test", server.Option{})
+	s.On("/echo", protocol.RpcMethodSet, func(req, res *protocol.Message) {
+		res.Param = req.Param
+		res.Data = req.Data
+	})
+	addr := startTestServer(t, s)
+	defer s.Close()
+
+	c := NewClient(Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	const workers, calls = 32, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				// large frames need more than one write syscall
+				req := protocol.NewMessage()
+				req.Param = []byte(fmt.Sprintf(`{"worker":%d,"call":%d}`, w, i))
+				req.Data = bytes.Repeat([]byte{byte(w)}, 32*1024+i)
+
+				reply, err := c.Call("/echo", protocol.TypeRPC, protocol.RpcMethodSet, req)
+				if err != nil {
+					errs <- fmt.Errorf("worker %d call %d: %v", w, i, err)
+					return
+				}
+				if !bytes.Equal(reply.Param, req.Param) || !bytes.Equal(reply.Data, req.Data) {
+					errs <- fmt.Errorf("worker %d call %d: reply %s with %d bytes does not match the request",
+						w, i, reply.Param, len(reply.Data))
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2023 ACOAUTO Team.
+// All rights reserved.
+//
+// Detailed license information can be found in the LICENSE file.
+//
+// File: codec.go Vehicle SOA protocal package.
+//
+// Author: Cheng.siyuan <chengsiyuan@acoinfo.com>
+
+// Package protocol encodes and decodes VSOA messages.
+//
+// Only the JSON Param codec is built in. CBOR, MessagePack or Protobuf
+// codecs are not shipped, applications register their own adapter with
+// RegisterCodec on both peers.
+package protocol
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// CodecJSON is the name of the default Param codec.
+const CodecJSON = "json"
+
+// Codec encodes and decodes the Param of typed RPC calls.
+//
+// Param is a json.RawMessage only by its type, its bytes are sent as they
+// are, so codecs like CBOR, MessagePack or Protobuf can be registered with
+// RegisterCodec. The codec of a connection is negotiated in ServInfo.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return CodecJSON }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{CodecJSON: jsonCodec{}}
+)
+
+// RegisterCodec makes c available by its name, it replaces the codec
+// registered with the same name. Both peers must register the codec.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[c.Name()] = c
+}
+
+// GetCodec returns the codec registered with name, nil if there is none.
+// Empty name is the JSON codec.
+func GetCodec(name string) Codec {
+	if name == "" {
+		name = CodecJSON
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	return codecs[name]
+}
+
+// SelectCodec returns the first registered codec in names, the JSON codec
+// if none of them is registered.
+func SelectCodec(names []string) Codec {
+	for _, name := range names {
+		if c := GetCodec(name); c != nil {
+			return c
+		}
+	}
+	return jsonCodec{}
+}
@@ -108,6 +108,10 @@ func (h Header) IsUnSubscribe() bool {
 	return h[1] == 0x03
 }
 
+func (h Header) IsQosSetup() bool {
+	return h[1] == 0x06
+}
+
 func (h Header) IsOneway() bool {
 	return h[1] == 0x05 || h[1] == 0x04
 }
@@ -230,7 +234,11 @@ func PutData(data *[]byte) {
 }
 
 func (m *Message) Decode(r io.Reader) error {
-	if _, err := io.ReadFull(r, m.Header[:]); err != nil || !m.Check() {
+	if _, err := io.ReadFull(r, m.Header[:]); err != nil {
+		// io.EOF tells the peer has closed the connection
+		return err
+	}
+	if !m.Check() {
 		return fmt.Errorf("invalid header")
 	}
 
@@ -0,0 +1,68 @@
+// Copyright (c) 2023 ACOAUTO Team.
+// All rights reserved.
+//
+// Detailed license information can be found in the LICENSE file.
+//
+// File: qos.go Vehicle SOA protocal package.
+//
+// Author: Cheng.siyuan <chengsiyuan@acoinfo.com>
+
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// QoS limits accepted by VSOA servers.
+const (
+	MaxQosPriority   = 7
+	MinQosBufferSize = 4 * 1024
+	MaxQosBufferSize = 4 * 1024 * 1024
+)
+
+var (
+	ErrQosPriority    = errors.New("QoS priority out of range")
+	ErrQosBufferSize  = errors.New("QoS socket buffer size out of range")
+	ErrQosPublishRate = errors.New("QoS publish rate should not be negative")
+)
+
+// QosSetupParam is the Param of TypeQosSetup request, server echoes the
+// accepted settings back in the reply Param.
+// Zero value of each field means keep the server default.
+type QosSetupParam struct {
+	Priority    int `json:"priority,omitempty"`    // 0 (lowest) to MaxQosPriority
+	SendBuffer  int `json:"sendBuffer,omitempty"`  // server side socket send buffer in bytes
+	RecvBuffer  int `json:"recvBuffer,omitempty"`  // server side socket receive buffer in bytes
+	PublishRate int `json:"publishRate,omitempty"` // max publishes per second to this client
+}
+
+// Validate checks if the QoS settings are inside VSOA limits.
+func (q QosSetupParam) Validate() error {
+	if q.Priority < 0 || q.Priority > MaxQosPriority {
+		return ErrQosPriority
+	}
+	if q.SendBuffer != 0 && (q.SendBuffer < MinQosBufferSize || q.SendBuffer > MaxQosBufferSize) {
+		return ErrQosBufferSize
+	}
+	if q.RecvBuffer != 0 && (q.RecvBuffer < MinQosBufferSize || q.RecvBuffer > MaxQosBufferSize) {
+		return ErrQosBufferSize
+	}
+	if q.PublishRate < 0 {
+		return ErrQosPublishRate
+	}
+	return nil
+}
+
+func (q QosSetupParam) NewMessage(req *Message) {
+	req.SetMessageType(TypeQosSetup)
+	req.SetReply(false)
+
+	req.Param, _ = json.Marshal(q)
+	req.Data = nil
+}
+
+func DecodeQosSetup(m json.RawMessage) (q QosSetupParam, err error) {
+	err = json.Unmarshal(m, &q)
+	return q, err
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2023 ACOAUTO Team.
+// All rights reserved.
+//
+// Detailed license information can be found in the LICENSE file.
+//
+// File: quick_auth.go Vehicle SOA protocal package.
+//
+// Author: Cheng.siyuan <chengsiyuan@acoinfo.com>
+
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// QuickTokenSize is the size of the quick channel session token.
+	QuickTokenSize = 32
+	// QuickTagSize is the size of the tag appended to authenticated quick packets.
+	QuickTagSize = 16
+
+	// QuickSignOverhead is the number of bytes added to a signed quick packet.
+	QuickSignOverhead = quickSeqSize + QuickTagSize
+)
+
+// NewQuickToken returns a random quick channel session token.
+func NewQuickToken() ([]byte, error) {
+	token := make([]byte, QuickTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// QuickSigner signs and verifies quick channel packets of one connection
+// with the session token given in the ServInfo reply.
+//
+// A signed packet is the 8 bytes sequence number, the encoded message and
+// the HMAC-SHA256 tag of both. Verified sequence numbers are remembered in
+// a sliding window like QuickCipher, so replayed packets are rejected.
+type QuickSigner struct {
+	token []byte
+
+	sendSeq atomic.Uint64
+
+	mu     sync.Mutex // protects replay
+	replay replayWindow
+}
+
+// NewQuickSigner returns the signer of the session token.
+func NewQuickSigner(token []byte) *QuickSigner {
+	return &QuickSigner{token: token}
+}
+
+// Sign returns the signed packet of frame.
+func (s *QuickSigner) Sign(frame []byte) []byte {
+	packet := make([]byte, quickSeqSize, QuickSignOverhead+len(frame))
+	binary.BigEndian.PutUint64(packet, s.sendSeq.Add(1))
+	packet = append(packet, frame...)
+	return append(packet, s.tag(packet)...)
+}
+
+// Verify returns the frame in packet, it fails if packet is forged or replayed.
+func (s *QuickSigner) Verify(packet []byte) ([]byte, error) {
+	if len(packet) < QuickSignOverhead {
+		return nil, ErrQuickPacketInvalid
+	}
+	seq := binary.BigEndian.Uint64(packet)
+	if seq == 0 {
+		return nil, ErrQuickPacketInvalid
+	}
+	signed, tag := packet[:len(packet)-QuickTagSize], packet[len(packet)-QuickTagSize:]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.replay.seen(seq) {
+		return nil, ErrQuickPacketReplayed
+	}
+	if !hmac.Equal(tag, s.tag(signed)) {
+		return nil, ErrQuickPacketInvalid
+	}
+
+	s.replay.accept(seq)
+	return signed[quickSeqSize:], nil
+}
+
+func (s *QuickSigner) tag(signed []byte) []byte {
+	mac := hmac.New(sha256.New, s.token)
+	mac.Write(signed)
+	return mac.Sum(nil)[:QuickTagSize]
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2023 ACOAUTO Team.
+// All rights reserved.
+//
+// Detailed license information can be found in the LICENSE file.
+//
+// File: quick_seal.go Vehicle SOA protocal package.
+//
+// Author: Cheng.siyuan <chengsiyuan@acoinfo.com>
+
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrQuickPacketInvalid  = errors.New("quick packet can not be opened")
+	ErrQuickPacketReplayed = errors.New("quick packet is replayed or too old")
+)
+
+const (
+	// quickKeyLabel is the TLS exporter label of quick channel keys.
+	quickKeyLabel  = "EXPORTER-VSOA-quick-channel"
+	quickKeySize   = 32 // AES-256
+	quickSeqSize   = 8
+	replayWindowSz = 64
+
+	// QuickSealOverhead is the number of bytes added to a sealed quick packet.
+	QuickSealOverhead = quickSeqSize + 16
+)
+
+// QuickCipher seals and opens quick channel packets of one connection.
+//
+// Keys are exported from the TLS session of the normal channel, one for
+// each direction. A sealed packet is the 8 bytes sequence number followed
+// by the AES-GCM ciphertext of the encoded message. Opened sequence numbers
+// are remembered in a sliding window, so replayed packets are rejected.
+type QuickCipher struct {
+	seal cipher.AEAD
+	open cipher.AEAD
+
+	sendSeq atomic.Uint64
+
+	mu     sync.Mutex // protects replay
+	replay replayWindow
+}
+
+// replayWindow remembers the sequence numbers received lately, a sequence
+// number is accepted once and only if it is not too old.
+type replayWindow struct {
+	highest uint64 // highest accepted sequence number
+	window  uint64 // bit i set: highest-i accepted
+}
+
+// NewQuickCipher derives the quick channel keys from conn, which must have
+// finished its handshake. server tells which side of conn we are.
+func NewQuickCipher(conn *tls.Conn, server bool) (*QuickCipher, error) {
+	cs := conn.ConnectionState()
+	keys, err := cs.ExportKeyingMaterial(quickKeyLabel, nil, 2*quickKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	c2s, err := newGCM(keys[:quickKeySize])
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := newGCM(keys[quickKeySize:])
+	if err != nil {
+		return nil, err
+	}
+
+	if server {
+		return &QuickCipher{seal: s2c, open: c2s}, nil
+	}
+	return &QuickCipher{seal: c2s, open: s2c}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal returns the sealed packet of frame.
+func (c *QuickCipher) Seal(frame []byte) []byte {
+	seq := c.sendSeq.Add(1)
+
+	packet := make([]byte, quickSeqSize, quickSeqSize+len(frame)+c.seal.Overhead())
+	binary.BigEndian.PutUint64(packet, seq)
+
+	var nonce [12]byte
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return c.seal.Seal(packet, nonce[:], frame, packet[:quickSeqSize])
+}
+
+// Open returns the frame in packet, it fails if packet is forged or replayed.
+func (c *QuickCipher) Open(packet []byte) ([]byte, error) {
+	if len(packet) < QuickSealOverhead {
+		return nil, ErrQuickPacketInvalid
+	}
+	seq := binary.BigEndian.Uint64(packet)
+	if seq == 0 {
+		return nil, ErrQuickPacketInvalid
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.replay.seen(seq) {
+		return nil, ErrQuickPacketReplayed
+	}
+
+	var nonce [12]byte
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	frame, err := c.open.Open(nil, nonce[:], packet[quickSeqSize:], packet[:quickSeqSize])
+	if err != nil {
+		return nil, ErrQuickPacketInvalid
+	}
+
+	c.replay.accept(seq)
+	return frame, nil
+}
+
+// seen reports whether seq is accepted before or is out of the window.
+func (w *replayWindow) seen(seq uint64) bool {
+	if seq > w.highest {
+		return false
+	}
+	diff := w.highest - seq
+	if diff >= replayWindowSz {
+		return true
+	}
+	return w.window&(1<<diff) != 0
+}
+
+func (w *replayWindow) accept(seq uint64) {
+	if seq > w.highest {
+		shift := seq - w.highest
+		if shift >= replayWindowSz {
+			w.window = 0
+		} else {
+			w.window <<= shift
+		}
+		w.window |= 1
+		w.highest = seq
+		return
+	}
+	w.window |= 1 << (w.highest - seq)
+}
@@ -21,14 +21,20 @@ var (
 )
 
 type ServInfoReqParam struct {
-	Password     string `json:"passwd,omitempty"`
-	PingInterval int    `json:"pingInterval,omitempty"`
-	PingTimeout  int    `json:"pingTimeout,omitempty"`
-	PingLost     int32  `json:"pingLost,omitempty"`
+	Password     string   `json:"passwd,omitempty"`
+	Token        string   `json:"token,omitempty"` // made by NewToken
+	PingInterval int      `json:"pingInterval,omitempty"`
+	PingTimeout  int      `json:"pingTimeout,omitempty"`
+	PingLost     int32    `json:"pingLost,omitempty"`
+	QuickAEAD    bool     `json:"quickAead,omitempty"` // ask for a sealed quick channel, TLS only
+	Codecs       []string `json:"codecs,omitempty"`    // Param codecs supported by client, preferred first
 }
 
 type ServInfoResParam struct {
-	Info string `json:"info"` // it should be JSON, but in real world it's a string
+	Info       string `json:"info"`                 // it should be JSON, but in real world it's a string
+	QuickAEAD  bool   `json:"quickAead,omitempty"`  // quick channel is sealed by QuickCipher
+	QuickToken []byte `json:"quickToken,omitempty"` // quick packets must be signed by QuickSigner with it
+	Codec      string `json:"codec,omitempty"`      // Param codec selected by server, empty is JSON
 }
 
 type ServInfoResData struct {
@@ -93,6 +99,39 @@ func DecodeServInfo(m json.RawMessage) string {
 	}
 }
 
+// DecodeServInfoRes returns the ServInfo reply param m,
+// only Info is set if m is a string.
+func DecodeServInfoRes(m json.RawMessage) *ServInfoResParam {
+	infoParam := new(ServInfoResParam)
+	if err := json.Unmarshal([]byte(m), infoParam); err != nil {
+		return &ServInfoResParam{Info: string(m)}
+	}
+	return infoParam
+}
+
 func GetClientUid(u []byte) uint32 {
 	return binary.BigEndian.Uint32(u)
 }
+
+// NewShutdownNotice makes m the notice sent by server before a graceful
+// shutdown closes the connection. It is a ServInfo request from server
+// with StatusNoResponding, clients should not send new requests after it.
+//
+// The notice is a go-vsoa extension of the VSOA protocol, where ServInfo
+// is only sent by clients. Servers send it only if enabled, clients not
+// knowing it see a ServInfo message they did not ask for.
+func NewShutdownNotice(m *Message) {
+	m.SetMessageType(TypeServInfo)
+	m.SetStatusType(StatusNoResponding)
+	m.SetReply(false)
+	m.SetSeqNo(0)
+
+	m.URL = nil
+	m.Param = nil
+	m.Data = nil
+}
+
+// IsShutdownNotice reports whether m is a server shutdown notice.
+func IsShutdownNotice(m *Message) bool {
+	return m.IsServInfo() && !m.IsReply() && m.StatusType() == StatusNoResponding
+}
@@ -30,6 +30,8 @@ func StatusText(code StatusType) string {
 		return "Success"
 	case StatusPassword:
 		return "Password error"
+	case StatusArguments:
+		return "Arguments error"
 	case StatusInvalidUrl:
 		return "Invalid URL"
 	case StatusNoResponding:
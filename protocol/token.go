@@ -0,0 +1,78 @@
+// Copyright (c) 2023 ACOAUTO Team.
+// All rights reserved.
+//
+// Detailed license information can be found in the LICENSE file.
+//
+// File: token.go Vehicle SOA protocal package.
+//
+// Author: Cheng.siyuan <chengsiyuan@acoinfo.com>
+
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// TokenClaims is the content of a ServInfo authentication token.
+type TokenClaims struct {
+	Name   string   `json:"name"`
+	Roles  []string `json:"roles,omitempty"`
+	Expiry int64    `json:"exp"` // Unix time in seconds, 0 means never expire
+}
+
+// NewToken signs claims with key using HMAC-SHA256.
+// The token is "base64url(claims JSON).base64url(signature)".
+func NewToken(key []byte, claims TokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	p := base64.RawURLEncoding.EncodeToString(payload)
+	return p + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(key, p)), nil
+}
+
+// VerifyToken checks the signature of token with key and its expiry at now,
+// and returns the claims inside it.
+func VerifyToken(key []byte, token string, now time.Time) (*TokenClaims, error) {
+	p, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrTokenInvalid
+	}
+
+	s, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(s, tokenSignature(key, p)) {
+		return nil, ErrTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	claims := new(TokenClaims)
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	if claims.Expiry != 0 && now.Unix() >= claims.Expiry {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+func tokenSignature(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
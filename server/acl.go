@@ -0,0 +1,156 @@
+package server
+
+import (
+	"errors"
+	"math/bits"
+	"slices"
+	"sync"
+)
+
+var (
+	ErrInvalidOperation = errors.New("ACL rule has no valid operation")
+)
+
+// Operation is a client operation checked by ACL rules.
+type Operation uint8
+
+const (
+	OpGet       Operation = 1 << iota // RPC GET
+	OpSet                             // RPC SET
+	OpSubscribe                       // subscribe and receive publishes
+	OpDatagram                        // DATAGRAM on normal and quick channel
+
+	OpRPC = OpGet | OpSet
+	OpAll = OpGet | OpSet | OpSubscribe | OpDatagram
+)
+
+const numOperations = 4
+
+// ACLRule allows or denies operations on the URLs matched by Pattern.
+//
+// Pattern uses the route rules: "/a/b" only matches "/a/b", "/a/b/"
+// matches "/a/b" and all URLs under it, ":name" matches one segment.
+// A rule without Roles and Names applies to all clients, otherwise it
+// applies to clients having one of the Roles or one of the Names.
+type ACLRule struct {
+	Pattern string
+	Ops     Operation
+	Roles   []string
+	Names   []string
+	Deny    bool
+}
+
+func (r *ACLRule) applies(id *Identity) bool {
+	if len(r.Roles) == 0 && len(r.Names) == 0 {
+		return true
+	}
+	if id == nil {
+		return false
+	}
+	if slices.Contains(r.Names, id.Name) {
+		return true
+	}
+	for _, role := range r.Roles {
+		if id.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL is a set of access rules evaluated against client identities.
+//
+// For an operation on a URL, only the rules of the longest matched pattern
+// are used. The operation is denied if a deny rule applies to the client,
+// or if no allow rule applies. URLs without matched rules are allowed,
+// unless DefaultDeny is set.
+type ACL struct {
+	DefaultDeny bool
+
+	mu    sync.RWMutex
+	trees [numOperations]routeTree
+	rules [numOperations]map[string][]ACLRule // key: pattern
+}
+
+// NewACL returns an ACL with rules.
+func NewACL(rules ...ACLRule) (*ACL, error) {
+	a := new(ACL)
+	for _, rule := range rules {
+		if err := a.Add(rule); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Add adds a rule, it can be called while the server is running.
+func (a *ACL) Add(rule ACLRule) error {
+	if rule.Ops&OpAll == 0 {
+		return ErrInvalidOperation
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := 0; i < numOperations; i++ {
+		if rule.Ops&(1<<i) == 0 {
+			continue
+		}
+		if a.rules[i] == nil {
+			a.rules[i] = make(map[string][]ACLRule)
+		}
+		if _, ok := a.rules[i][rule.Pattern]; !ok {
+			if err := a.trees[i].add(rule.Pattern, serverHandler{}); err != nil {
+				return err
+			}
+		}
+		a.rules[i][rule.Pattern] = append(a.rules[i][rule.Pattern], rule)
+	}
+	return nil
+}
+
+// Allowed reports whether the client with id can do op on URL.
+// id is nil if the client is not authenticated.
+func (a *ACL) Allowed(id *Identity, op Operation, URL string) bool {
+	if bits.OnesCount8(uint8(op&OpAll)) != 1 {
+		return false
+	}
+	i := bits.TrailingZeros8(uint8(op))
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	entry, _ := a.trees[i].lookup(URL)
+	if entry == nil {
+		return !a.DefaultDeny
+	}
+
+	allowed := false
+	for _, rule := range a.rules[i][entry.pattern] {
+		if !rule.applies(id) {
+			continue
+		}
+		if rule.Deny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// authorize checks op on URL of a client with Option.ACL.
+func (s *Server) authorize(clientUid uint32, op Operation, URL string) bool {
+	acl := s.option.ACL
+	if acl == nil {
+		return true
+	}
+
+	s.mu.RLock()
+	var id *Identity
+	if c, ok := s.clients[clientUid]; ok {
+		id = c.identity
+	}
+	s.mu.RUnlock()
+
+	return acl.Allowed(id, op, URL)
+}
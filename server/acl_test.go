@@ -0,0 +1,74 @@
+package server
+
+import (
+	"testing"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+func TestACLAllowed(t *testing.T) {
+	acl, err := NewACL(
+		ACLRule{Pattern: "/vehicle/", Ops: OpGet | OpSubscribe},
+		ACLRule{Pattern: "/vehicle/", Ops: OpSet, Roles: []string{"admin"}},
+		ACLRule{Pattern: "/vehicle/:id/door", Ops: OpAll, Names: []string{"body"}},
+		ACLRule{Pattern: "/vehicle/:id/door", Ops: OpAll, Roles: []string{"guest"}, Deny: true},
+	)
+	if err != nil {
+		t.Fatalf("new ACL: %v", err)
+	}
+
+	admin := &Identity{Name: "console", Roles: []string{"admin"}}
+	body := &Identity{Name: "body", Roles: []string{"guest"}}
+
+	tests := []struct {
+		id   *Identity
+		op   Operation
+		url  string
+		want bool
+	}{
+		{nil, OpGet, "/vehicle/speed", true},
+		{nil, OpSet, "/vehicle/speed", false},
+		{admin, OpSet, "/vehicle/speed", true},
+		{nil, OpDatagram, "/vehicle/speed", true}, // no DATAGRAM rules
+		{admin, OpGet, "/vehicle/1/door", false},  // longest match only
+		{body, OpGet, "/vehicle/1/door", false},   // deny wins
+		{&Identity{Name: "body"}, OpSet, "/vehicle/1/door", true},
+		{nil, OpGet, "/other", true},
+		{nil, OpRPC, "/vehicle/speed", false}, // one operation at a time
+	}
+	for _, tt := range tests {
+		if got := acl.Allowed(tt.id, tt.op, tt.url); got != tt.want {
+			t.Errorf("%+v op %d %s: got %v, want %v", tt.id, tt.op, tt.url, got, tt.want)
+		}
+	}
+
+	acl.DefaultDeny = true
+	if acl.Allowed(nil, OpGet, "/other") {
+		t.Error("DefaultDeny allowed URL without rules")
+	}
+}
+
+func TestACLNoPermissions(t *testing.T) {
+	acl, _ := NewACL(ACLRule{Pattern: "/secret", Ops: OpRPC | OpSubscribe, Roles: []string{"admin"}})
+
+	s := NewServer("test", Option{ACL: acl})
+	s.On("/secret", protocol.RpcMethodGet, func(req, res *protocol.Message) {})
+	s.RegisterPublishURL("/secret")
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	want := protocol.StatusText(protocol.StatusNoPermissions)
+	if _, err := c.Call("/secret", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage()); err == nil || err.Error() != want {
+		t.Errorf("call: got %v, want %q", err, want)
+	}
+	if err := c.Subscribe("/secret", nil); err == nil || err.Error() != want {
+		t.Errorf("subscribe: got %v, want %q", err, want)
+	}
+}
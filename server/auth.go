@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+var (
+	ErrNoPeerCertificate  = errors.New("no TLS client certificate")
+	ErrUnknownCertificate = errors.New("TLS client certificate subject is not allowed")
+)
+
+// AuthRequest is what an Authenticator gets from a connecting client.
+type AuthRequest struct {
+	Param      protocol.ServInfoReqParam // ServInfo request params
+	RemoteAddr net.Addr
+	// TLS client certificates, empty if the client has no certificate
+	PeerCertificates []*x509.Certificate
+}
+
+// Identity is an authenticated client.
+type Identity struct {
+	Name  string
+	Roles []string
+}
+
+// HasRole reports whether the identity has role.
+func (id *Identity) HasRole(role string) bool {
+	return id != nil && slices.Contains(id.Roles, role)
+}
+
+// Authenticator authenticates clients in the ServInfo handshake.
+// A client rejected with an error gets protocol.StatusPassword.
+type Authenticator interface {
+	Authenticate(req *AuthRequest) (*Identity, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(req *AuthRequest) (*Identity, error)
+
+// Authenticate calls f(req).
+func (f AuthenticatorFunc) Authenticate(req *AuthRequest) (*Identity, error) {
+	return f(req)
+}
+
+// PasswordAuthenticator accepts clients sending the shared Password.
+// It is used for Option.Password if Option.Authenticator is not set.
+type PasswordAuthenticator struct {
+	Password string
+	Identity Identity // identity of all accepted clients
+}
+
+func (a *PasswordAuthenticator) Authenticate(req *AuthRequest) (*Identity, error) {
+	if subtle.ConstantTimeCompare([]byte(req.Param.Password), []byte(a.Password)) != 1 {
+		return nil, protocol.ErrMessagePasswd
+	}
+	id := a.Identity
+	return &id, nil
+}
+
+// TokenAuthenticator accepts clients sending a token made by
+// protocol.NewToken with Key, which has not expired.
+// The identity is the name and roles inside the token.
+type TokenAuthenticator struct {
+	Key []byte
+}
+
+func (a *TokenAuthenticator) Authenticate(req *AuthRequest) (*Identity, error) {
+	claims, err := protocol.VerifyToken(a.Key, req.Param.Token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{Name: claims.Name, Roles: claims.Roles}, nil
+}
+
+// CertAuthenticator accepts clients by the subject of their TLS client
+// certificate. Certificates must be verified by the server TLS config,
+// e.g. with ClientAuth set to tls.RequireAndVerifyClientCert.
+type CertAuthenticator struct {
+	// Subjects maps certificate subject common names to identities.
+	// If it is nil, all clients are accepted with the common name as
+	// identity name and the organizational units as roles.
+	Subjects map[string]Identity
+}
+
+func (a *CertAuthenticator) Authenticate(req *AuthRequest) (*Identity, error) {
+	if len(req.PeerCertificates) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+
+	subject := req.PeerCertificates[0].Subject
+	if a.Subjects == nil {
+		return &Identity{Name: subject.CommonName, Roles: subject.OrganizationalUnit}, nil
+	}
+	id, ok := a.Subjects[subject.CommonName]
+	if !ok {
+		return nil, ErrUnknownCertificate
+	}
+	return &id, nil
+}
+
+// authenticate checks the ServInfo request of a client and returns the
+// client identity, it is nil if the server has no authentication.
+func (s *Server) authenticate(req *protocol.Message, ClientUid uint32) (*Identity, error) {
+	auth := s.option.Authenticator
+	if auth == nil {
+		if s.option.Password == "" {
+			return nil, nil
+		}
+		auth = &PasswordAuthenticator{Password: s.option.Password}
+	}
+
+	ar := new(AuthRequest)
+	if err := json.Unmarshal(req.Param, &ar.Param); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	conn := s.clients[ClientUid].Conn
+	s.mu.RUnlock()
+
+	ar.RemoteAddr = conn.RemoteAddr()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		ar.PeerCertificates = tlsConn.ConnectionState().PeerCertificates
+	}
+
+	return auth.Authenticate(ar)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	key := []byte("secret")
+	auth := &TokenAuthenticator{Key: key}
+
+	valid, _ := protocol.NewToken(key, protocol.TokenClaims{
+		Name: "dashboard", Roles: []string{"viewer"}, Expiry: time.Now().Add(time.Hour).Unix()})
+	expired, _ := protocol.NewToken(key, protocol.TokenClaims{
+		Name: "dashboard", Expiry: time.Now().Add(-time.Second).Unix()})
+	forged, _ := protocol.NewToken([]byte("other"), protocol.TokenClaims{Name: "dashboard"})
+
+	tests := []struct {
+		token   string
+		wantErr error
+	}{
+		{valid, nil},
+		{expired, protocol.ErrTokenExpired},
+		{forged, protocol.ErrTokenInvalid},
+		{"", protocol.ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		id, err := auth.Authenticate(&AuthRequest{Param: protocol.ServInfoReqParam{Token: tt.token}})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("token %q: got err %v, want %v", tt.token, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (id.Name != "dashboard" || !id.HasRole("viewer")) {
+			t.Errorf("token %q: got identity %+v", tt.token, id)
+		}
+	}
+}
+
+func TestCertAuthenticator(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ecu-1", OrganizationalUnit: []string{"body"}}}
+	req := &AuthRequest{PeerCertificates: []*x509.Certificate{cert}}
+
+	id, err := (&CertAuthenticator{}).Authenticate(req)
+	if err != nil || id.Name != "ecu-1" || !id.HasRole("body") {
+		t.Fatalf("subject identity: got %+v, %v", id, err)
+	}
+
+	auth := &CertAuthenticator{Subjects: map[string]Identity{"ecu-2": {Name: "ecu-2"}}}
+	if _, err := auth.Authenticate(req); err != ErrUnknownCertificate {
+		t.Fatalf("expected ErrUnknownCertificate, got %v", err)
+	}
+	if _, err := auth.Authenticate(&AuthRequest{}); err != ErrNoPeerCertificate {
+		t.Fatalf("expected ErrNoPeerCertificate, got %v", err)
+	}
+}
+
+func TestAuthenticatorIdentityInContext(t *testing.T) {
+	key := []byte("secret")
+	s := NewServer("test", Option{Authenticator: &TokenAuthenticator{Key: key}})
+	s.On("/whoami", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		res.Param, _ = json.Marshal(s.Context(req).Identity.Name)
+	})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	if _, err := c.Connect("vsoa", addr); err == nil {
+		t.Fatal("client without token connected")
+	}
+
+	token, _ := protocol.NewToken(key, protocol.TokenClaims{Name: "dashboard"})
+	c = vsoaclient.NewClient(vsoaclient.Option{
+		Credentials: vsoaclient.StaticCredentials(vsoaclient.Credentials{Token: token}),
+	})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect with token: %v", err)
+	}
+	defer c.Close()
+
+	reply, err := c.Call("/whoami", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage())
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if string(reply.Param) != `"dashboard"` {
+		t.Fatalf("got identity %s, want \"dashboard\"", reply.Param)
+	}
+}
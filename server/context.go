@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// Context is the request context of RPC and DATAGRAM handlers.
+//
+// It is a context.Context cancelled when the client connection is closed,
+// so it can be passed to the work started by the handler.
+type Context struct {
+	context.Context
+
+	ClientUid  uint32
+	RemoteAddr net.Addr       // client normal channel address
+	QuickAddr  *net.UDPAddr   // client quick channel address, nil if not used
+	Authed     bool           // if publishes goto the client
+	Params     Params         // URL parameters matched by the route
+	Identity   *Identity      // given by Option.Authenticator, nil without authentication
+	Codec      protocol.Codec // Param codec of the client, JSON by default
+
+	values *sync.Map
+}
+
+// Get returns the value saved with key for the client.
+func (ctx *Context) Get(key string) (value any, ok bool) {
+	if ctx.values == nil {
+		return nil, false
+	}
+	return ctx.values.Load(key)
+}
+
+// Set saves value with key for the client, it is kept until the client
+// connection is closed and shared by all requests of the client.
+func (ctx *Context) Set(key string, value any) {
+	if ctx.values != nil {
+		ctx.values.Store(key, value)
+	}
+}
+
+// Delete removes the value saved with key for the client.
+func (ctx *Context) Delete(key string) {
+	if ctx.values != nil {
+		ctx.values.Delete(key)
+	}
+}
+
+// Context returns the request context of req.
+// It is only valid inside the handler and middleware of req, otherwise nil.
+func (s *Server) Context(req *protocol.Message) *Context {
+	if ctx, ok := s.requests.Load(req); ok {
+		return ctx.(*Context)
+	}
+	return nil
+}
+
+// newContext creates the request context of a client request.
+func (s *Server) newContext(clientUid uint32, params Params) *Context {
+	ctx := &Context{
+		Context:   context.Background(),
+		ClientUid: clientUid,
+		Params:    params,
+		Codec:     protocol.GetCodec(protocol.CodecJSON),
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if c, ok := s.clients[clientUid]; ok {
+		if c.ctx != nil {
+			ctx.Context = c.ctx
+		}
+		if c.Conn != nil {
+			ctx.RemoteAddr = c.Conn.RemoteAddr()
+		}
+		ctx.QuickAddr = c.QAddr
+		ctx.Authed = c.Authed
+		ctx.Identity = c.identity
+		if c.codec != nil {
+			ctx.Codec = c.codec
+		}
+		ctx.values = &c.values
+	}
+	return ctx
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+func TestContext(t *testing.T) {
+	s := NewServer("test", Option{AutoAuth: true})
+	got := make(chan Context, 1)
+	s.On("/dev/:id", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		ctx := s.Context(req)
+		if _, ok := ctx.Get("seen"); !ok {
+			ctx.Set("seen", true)
+			res.SetStatusType(protocol.StatusNoPermissions)
+			return
+		}
+		got <- *ctx
+	})
+	started, canceled := make(chan struct{}), make(chan error, 1)
+	s.On("/wait", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		ctx := s.Context(req)
+		close(started)
+		select {
+		case <-ctx.Done():
+			canceled <- ctx.Err()
+		case <-time.After(2 * time.Second):
+			canceled <- nil
+		}
+	})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	// the first call saves a value, the second one finds it
+	if _, err := c.Call("/dev/42", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage()); err == nil {
+		t.Fatal("first call: value already saved")
+	}
+	if _, err := c.Call("/dev/42", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage()); err != nil {
+		t.Fatalf("second call: %v", err)
+	}
+	ctx := <-got
+	if ctx.ClientUid != c.GetUid() {
+		t.Errorf("ClientUid %d, want %d", ctx.ClientUid, c.GetUid())
+	}
+	if addr, ok := ctx.RemoteAddr.(*net.TCPAddr); !ok || !addr.IP.IsLoopback() {
+		t.Errorf("RemoteAddr %v, want the client loopback address", ctx.RemoteAddr)
+	}
+	if !ctx.Authed {
+		t.Error("Authed false with AutoAuth")
+	}
+	if id := ctx.Params.ByName("id"); id != "42" {
+		t.Errorf("Params id %q, want 42", id)
+	}
+	if ctx.Identity != nil {
+		t.Errorf("Identity %+v without authentication", ctx.Identity)
+	}
+	if ctx.Codec.Name() != protocol.CodecJSON {
+		t.Errorf("Codec %s, want %s", ctx.Codec.Name(), protocol.CodecJSON)
+	}
+
+	// the context is cancelled when the client disconnects
+	c.Go("/wait", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage(), protocol.NewMessage(), nil)
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler not called")
+	}
+	c.Close()
+	if err := <-canceled; err == nil {
+		t.Fatal("context not cancelled after the client disconnected")
+	}
+}
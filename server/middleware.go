@@ -0,0 +1,153 @@
+package server
+
+import (
+	"log"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// Middleware wraps a RPC or DATAGRAM Handler with pre- and post-processing.
+//
+// A middleware can stop the request by setting the resp status
+// (e.g. protocol.StatusNoPermissions) and returning without calling next.
+// Use s.Context(req) inside it to get request information.
+type Middleware func(next Handler) Handler
+
+// Use appends middleware to the server. Middleware wraps all RPC and
+// DATAGRAM handlers, the first one is the outermost.
+func (s *Server) Use(middleware ...Middleware) {
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
+
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// ClientUid returns the UID of the client that sent req.
+// It is only valid inside the handler and middleware of req.
+func (s *Server) ClientUid(req *protocol.Message) uint32 {
+	if ctx := s.Context(req); ctx != nil {
+		return ctx.ClientUid
+	}
+	return 0
+}
+
+// chain wraps h with server middleware and the middleware of group and its parents.
+func (s *Server) chain(g *Group, h Handler) Handler {
+	s.router.mu.RLock()
+	middleware := append([]Middleware(nil), s.middleware...)
+	var groups []*Group
+	for ; g != nil; g = g.parent {
+		groups = append(groups, g)
+	}
+	for i := len(groups) - 1; i >= 0; i-- {
+		middleware = append(middleware, groups[i].middleware...)
+	}
+	s.router.mu.RUnlock()
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Group is a set of routes sharing URL prefix and middleware.
+type Group struct {
+	s          *Server
+	parent     *Group
+	prefix     string
+	middleware []Middleware // protected by s.router.mu
+}
+
+// Group creates a route group, all routes of the group are registered
+// under prefix and wrapped by the group middleware after server middleware.
+func (s *Server) Group(prefix string, middleware ...Middleware) *Group {
+	return &Group{
+		s:          s,
+		prefix:     strings.TrimSuffix(prefix, "/"),
+		middleware: middleware,
+	}
+}
+
+// Group creates a sub route group under g.
+func (g *Group) Group(prefix string, middleware ...Middleware) *Group {
+	return &Group{
+		s:          g.s,
+		parent:     g,
+		prefix:     strings.TrimSuffix(g.prefix+"/"+strings.Trim(prefix, "/"), "/"),
+		middleware: middleware,
+	}
+}
+
+// Use appends middleware to the group.
+func (g *Group) Use(middleware ...Middleware) {
+	g.s.router.mu.Lock()
+	defer g.s.router.mu.Unlock()
+
+	g.middleware = append(g.middleware, middleware...)
+}
+
+func (g *Group) path(servicePath string) string {
+	if !strings.HasPrefix(servicePath, "/") {
+		servicePath = "/" + servicePath
+	}
+	return g.prefix + servicePath
+}
+
+// On adds an RPC handler under the group prefix.
+func (g *Group) On(servicePath string, serviceMethod protocol.RpcMessageType, handler func(*protocol.Message, *protocol.Message)) (err error) {
+	if handler == nil {
+		return ErrNilHandler
+	}
+	g.s.router.mu.Lock()
+	defer g.s.router.mu.Unlock()
+	return g.s.router.rpcTree(serviceMethod).add(g.path(servicePath), serverHandler{handler: handler, rawFlag: false, group: g})
+}
+
+// OnDatagram adds a DATAGRAME handler under the group prefix.
+func (g *Group) OnDatagram(servicePath string, handler func(*protocol.Message, *protocol.Message)) (err error) {
+	if handler == nil {
+		return ErrNilHandler
+	}
+	g.s.router.mu.Lock()
+	defer g.s.router.mu.Unlock()
+	return g.s.router.datagram.add(g.path(servicePath), serverHandler{handler: handler, rawFlag: false, group: g})
+}
+
+// Recovery returns a middleware that recovers handler panics, logs them
+// and replies protocol.StatusNoResponding.
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return func(req, resp *protocol.Message) {
+			defer func() {
+				if r := recover(); r != nil {
+					buf := make([]byte, 1024)
+					buf = buf[:runtime.Stack(buf, false)]
+
+					log.Printf("handler of %s panic: %v, stack: %s", req.URL, r, buf)
+					resp.SetStatusType(protocol.StatusNoResponding)
+				}
+			}()
+			next(req, resp)
+		}
+	}
+}
+
+// Logger returns a middleware that logs every request with the client
+// UID, the status and the latency.
+func (s *Server) Logger() Middleware {
+	return func(next Handler) Handler {
+		return func(req, resp *protocol.Message) {
+			t0 := time.Now()
+			next(req, resp)
+			if req.IsRPC() {
+				log.Printf("Vsoa client[%d] RPC %s %s: %s in %v", s.ClientUid(req),
+					req.MessageRpcMethodText(), req.URL, resp.StatusTypeText(), time.Since(t0))
+			} else {
+				log.Printf("Vsoa client[%d] DATAGRAM %s in %v", s.ClientUid(req), req.URL, time.Since(t0))
+			}
+		}
+	}
+}
@@ -1,11 +1,9 @@
 package server
 
 import (
-	"context"
+	"encoding/json"
 	"log"
-	"net"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/acoinfo/vsoa/protocol"
@@ -16,75 +14,121 @@ import (
 // Parameters:
 // - servicePath: a string representing the service path to publish.
 // - timeDriction: a time.Duration value representing the time interval between each publish message.
+// - filter: selects the clients to publish to, nil selects all subscribed clients.
 // - pubs: a function that takes two parameters: a pointer to a protocol.Message and a pointer to another protocol.Message. It is called to initialize the request message before publishing.
-func (s *Server) publisher(servicePath string, timeOrTrigger any, pubs func(*protocol.Message, *protocol.Message)) {
+// - stop: closed by Unpublish to stop the publisher.
+func (s *Server) publisher(servicePath string, timeOrTrigger any, filter ClientFilter, pubs func(*protocol.Message, *protocol.Message), stop <-chan struct{}) {
 	req := protocol.NewMessage()
 
-	var ticker *time.Ticker
-	isTrigger := false
+	var tick <-chan time.Time
+	var trigger chan struct{}
 
 	switch v := timeOrTrigger.(type) {
 	case time.Duration:
-		ticker = time.NewTicker(v)
+		ticker := time.NewTicker(v)
 		defer ticker.Stop()
+		tick = ticker.C
 	case chan struct{}:
-		s.triggerChan[servicePath] = v
-		isTrigger = true
+		trigger = v
 	default:
 		panic("Invalid type for timeOrTrigger")
 	}
 
 	for {
-		var wg sync.WaitGroup
-		var ctx context.Context
-		var cancel context.CancelFunc
-		var timeout time.Duration
-
-		if isTrigger {
-			<-s.triggerChan[servicePath]
-			timeout = time.Duration(len(s.clients)) * time.Millisecond
-			ctx, cancel = context.WithTimeout(context.Background(), timeout)
-		} else {
-			<-ticker.C
-			timeout = 4 * time.Duration(timeOrTrigger.(time.Duration)) / 5
-			ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		select {
+		case <-tick:
+		case <-trigger:
+		case <-stop:
+			return
+		case <-s.pubStop:
+			return
 		}
 
 		pubs(req, nil)
 
-		for _, c := range s.clients {
-			if s.isSubscribedToPath(c, servicePath) && c.Authed {
-				wg.Add(1)
-				go func(c *client) {
-					defer wg.Done()
-					reqCopy := *req // Aviod change req object at the same time.
-					reqCopy.URL = []byte(servicePath)
-					s.sendMessageWithContext(ctx, &reqCopy, c.Conn, timeout)
-				}(c)
-			}
+		frame, err := encodePublish(req, servicePath, protocol.ChannelNormal)
+		if err != nil {
+			log.Println("Error encoding publish:", err)
+			continue
 		}
+		s.fanout(frame, s.subscribersOf(servicePath, filter))
+	}
+}
 
-		done := make(chan struct{})
-		go func() {
-			wg.Wait()
-			close(done)
-		}()
+// encodePublish encodes req as a publish of servicePath.
+// The frame is shared by all subscribers, so it must not go back to pool.
+func encodePublish(req *protocol.Message, servicePath string, quick protocol.QuickChannelFlag) ([]byte, error) {
+	req.SetMessageType(protocol.TypePublish)
+	req.SetReply(false)
+	req.URL = []byte(servicePath)
 
-		select {
-		case <-done:
-			// All sends completed within the period
-		case <-ctx.Done():
-			// Timeout, 4/5 of the period elapsed
+	return req.Encode(quick)
+}
+
+// fanout queues one encoded publish frame to all subscribers.
+// A slow subscriber does not delay the others, its write queue overflow
+// policy decides what happens to the frame.
+func (s *Server) fanout(frame []byte, subscribers []*client) {
+	for _, c := range subscribers {
+		s.enqueue(c, frame, true)
+	}
+}
+
+// ClientFilter selects the clients a publish goes to.
+type ClientFilter func(clientUid uint32) bool
+
+// ClientUids returns a ClientFilter that selects the clients in uids.
+func ClientUids(uids ...uint32) ClientFilter {
+	set := make(map[uint32]struct{}, len(uids))
+	for _, uid := range uids {
+		set[uid] = struct{}{}
+	}
+	return func(clientUid uint32) bool {
+		_, ok := set[clientUid]
+		return ok
+	}
+}
+
+// subscribersOf returns authed clients subscribed to servicePath and selected
+// by filter, which are allowed by ACL and inside their QoS publish rate.
+func (s *Server) subscribersOf(servicePath string, filter ClientFilter) []*client {
+	// the limiter may be replaced by qosSetupHandler, copy it under lock
+	s.mu.RLock()
+	candidates := make([]pubCandidate, 0, len(s.clients))
+	for _, c := range s.clients {
+		if c.Active && c.Authed {
+			candidates = append(candidates, pubCandidate{c, c.pubLimiter})
+		}
+	}
+	s.mu.RUnlock()
+
+	selected := candidates[:0]
+	for _, pc := range candidates {
+		// filter is called without lock, it may use server APIs
+		c := pc.c
+		if (filter == nil || filter(c.Uid)) && s.isSubscribedToPath(c, servicePath) &&
+			(s.option.ACL == nil || s.option.ACL.Allowed(c.identity, OpSubscribe, servicePath)) &&
+			pc.limiter.allow() {
+			selected = append(selected, pc)
 		}
+	}
 
-		cancel()
+	subscribers := make([]*client, len(selected))
+	for i := range selected {
+		subscribers[i] = selected[i].c
 	}
+	return subscribers
 }
 
 func (s *Server) isSubscribedToPath(c *client, servicePath string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	return isSubscribedToPathLocked(c, servicePath)
+}
+
+// isSubscribedToPathLocked is isSubscribedToPath with s.mu held.
+func isSubscribedToPathLocked(c *client, servicePath string) bool {
 	// Normalize the service path (remove leading/trailing slashes)
 	normServicePath := strings.Trim(servicePath, "/")
 
@@ -112,45 +156,90 @@ func (s *Server) isSubscribedToPath(c *client, servicePath string) bool {
 	return false
 }
 
-// Normal channel Publish Message
-func (s *Server) sendMessageWithContext(ctx context.Context, req *protocol.Message, conn net.Conn, timeout time.Duration) {
-	select {
-	case <-ctx.Done():
-		// Context cancelled or timed out
-		return
-	default:
-		// Send the message
-		req.SetMessageType(protocol.TypePublish)
+// RegisterPublishURL registers servicePath for PublishMessage and
+// QuickPublishMessage, so clients can subscribe it before the first publish.
+// No publisher goroutine is started for it.
+func (s *Server) RegisterPublishURL(servicePath string) error {
+	if !strings.HasPrefix(servicePath, "/") {
+		servicePath = "/" + servicePath
+	}
 
-		req.SetReply(false)
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
 
-		tmp, err := req.Encode(protocol.ChannelNormal)
-		if err != nil {
-			log.Panicln(err)
-			return
-		}
+	return s.router.subs.add(servicePath, serverHandler{handler: nil, rawFlag: true})
+}
 
-		conn.SetWriteDeadline(time.Now().Add(timeout))
+// PublishMessage encodes one publish message and sends it to all subscribed
+// clients on the normal channel at once.
+// servicePath is registered by the first call if it is not registered yet.
+func (s *Server) PublishMessage(servicePath string, param json.RawMessage, data []byte) error {
+	return s.publishMessage(servicePath, param, data, protocol.ChannelNormal)
+}
 
-		_, err = conn.Write(tmp)
-		protocol.PutData(&tmp)
-		if err != nil {
-			if strings.Contains(err.Error(), "broken pipe") ||
-				strings.Contains(err.Error(), "connection reset by peer") {
-				s.mu.RLock()
-				for uid, client := range s.clients {
-					if client.Conn == conn {
-						s.mu.RUnlock()
-						s.closeConn(uid)
-						return
-					}
-				}
-				s.mu.RUnlock()
-			}
-			log.Println("Error writing to connection:", err)
-			return
+// QuickPublishMessage is like PublishMessage, but sends the publish on the quick channel.
+func (s *Server) QuickPublishMessage(servicePath string, param json.RawMessage, data []byte) error {
+	return s.publishMessage(servicePath, param, data, protocol.ChannelQuick)
+}
+
+func (s *Server) publishMessage(servicePath string, param json.RawMessage, data []byte, quick protocol.QuickChannelFlag) error {
+	if !strings.HasPrefix(servicePath, "/") {
+		servicePath = "/" + servicePath
+	}
+
+	if err := s.RegisterPublishURL(servicePath); err != nil && err != ErrAlreadyRegistered {
+		return err
+	}
+
+	req := protocol.NewMessage()
+	req.Param = param
+	req.Data = data
+
+	frame, err := encodePublish(req, servicePath, quick)
+	if err != nil {
+		return err
+	}
+
+	subscribers := s.subscribersOf(servicePath, nil)
+	if !quick {
+		s.fanout(frame, subscribers)
+		return nil
+	}
+	for _, c := range subscribers {
+		if c.QAddr != nil {
+			s.qsendMessage(frame, c)
 		}
+	}
+	return nil
+}
 
+// notifyUnpublished removes servicePath from client subscribes, and tells
+// all clients receiving its publishes, including the ones subscribed to a
+// parent URL, with an UNSUBSCRIBE message from server.
+func (s *Server) notifyUnpublished(servicePath string) {
+	msg := protocol.NewMessage()
+	msg.SetMessageType(protocol.TypeUnsubscribe)
+	msg.SetReply(false)
+	msg.URL = []byte(servicePath)
+
+	frame, err := msg.Encode(protocol.ChannelNormal)
+	if err != nil {
+		log.Println("Error encoding unsubscribe:", err)
 		return
 	}
+
+	var subscribers []*client
+	s.mu.Lock()
+	for _, c := range s.clients {
+		if isSubscribedToPathLocked(c, servicePath) {
+			// parent URL subscriptions still cover other routes
+			delete(c.Subscribes, servicePath)
+			subscribers = append(subscribers, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range subscribers {
+		s.enqueue(c, frame, false)
+	}
 }
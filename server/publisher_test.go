@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// discardConn is a net.Conn that drops all writes.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (discardConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+func benchmarkSubscribers(s *Server, n int) []*client {
+	subscribers := make([]*client, n)
+	for i := range subscribers {
+		c := &client{Uid: uint32(i + 1), Conn: discardConn{}, queue: newWriteQueue(0, DropOldest)}
+		go s.serveWriter(c)
+		subscribers[i] = c
+	}
+	return subscribers
+}
+
+func benchmarkPublish() *protocol.Message {
+	req := protocol.NewMessage()
+	req.Param = []byte(`{"calibration":true}`)
+	req.Data = make([]byte, 256*1024-1024)
+	return req
+}
+
+// BenchmarkPublishFanout compares encoding the publish once for all
+// subscribers with encoding it once per subscriber.
+func BenchmarkPublishFanout(b *testing.B) {
+	s := NewServer("bench", Option{})
+
+	for _, n := range []int{1, 10, 100, 500} {
+		subscribers := benchmarkSubscribers(s, n)
+
+		b.Run(fmt.Sprintf("EncodeOnce/%d", n), func(b *testing.B) {
+			req := benchmarkPublish()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				frame, err := encodePublish(req, "/bench", protocol.ChannelNormal)
+				if err != nil {
+					b.Fatal(err)
+				}
+				s.fanout(frame, subscribers)
+			}
+		})
+
+		b.Run(fmt.Sprintf("EncodePerSubscriber/%d", n), func(b *testing.B) {
+			req := benchmarkPublish()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for _, c := range subscribers {
+					wg.Add(1)
+					go func(conn net.Conn) {
+						defer wg.Done()
+						header := *req.Header
+						reqCopy := *req
+						reqCopy.Header = &header
+						frame, err := encodePublish(&reqCopy, "/bench", protocol.ChannelNormal)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						conn.Write(frame)
+					}(c.Conn)
+				}
+				wg.Wait()
+			}
+		})
+
+		for _, c := range subscribers {
+			c.queue.close()
+		}
+	}
+}
+
+// subscribeClient connects a client to addr and subscribes URL, the
+// publishes it receives are sent to the returned channel.
+func subscribeClient(t *testing.T, addr, URL string) (*vsoaclient.Client, chan *protocol.Message) {
+	t.Helper()
+
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	publishes := make(chan *protocol.Message, 16)
+	if err := c.Subscribe(URL, func(m *protocol.Message) {
+		publishes <- m
+	}); err != nil {
+		c.Close()
+		t.Fatalf("subscribe %s: %v", URL, err)
+	}
+	return c, publishes
+}
+
+func TestPublishTo(t *testing.T) {
+	s := NewServer("test", Option{AutoAuth: true})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	var clients []*vsoaclient.Client
+	var publishes []chan *protocol.Message
+	for i := 0; i < 3; i++ {
+		c, ch := subscribeClient(t, addr, "/")
+		defer c.Close()
+		clients = append(clients, c)
+		publishes = append(publishes, ch)
+	}
+
+	trigger := make(chan struct{}, 1)
+	filter := ClientUids(clients[0].GetUid(), clients[2].GetUid())
+	if err := s.PublishTo("/to", trigger, filter, func(req, res *protocol.Message) {
+		req.Data = []byte("to")
+	}); err != nil {
+		t.Fatalf("publish to: %v", err)
+	}
+	trigger <- struct{}{}
+
+	for _, i := range []int{0, 2} {
+		select {
+		case m := <-publishes[i]:
+			if string(m.URL) != "/to" || string(m.Data) != "to" {
+				t.Fatalf("client %d got %s %q", i, m.URL, m.Data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("selected client %d got no publish", i)
+		}
+	}
+	select {
+	case m := <-publishes[1]:
+		t.Fatalf("filtered out client got %s %q", m.URL, m.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPublishMessage(t *testing.T) {
+	s := NewServer("test", Option{AutoAuth: true})
+	for _, URL := range []string{"/m", "/other"} {
+		if err := s.RegisterPublishURL(URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+	waitQuickListener(t, s)
+
+	var subscribed []chan *protocol.Message
+	for _, URL := range []string{"/m", "/m", "/"} {
+		c, ch := subscribeClient(t, addr, URL)
+		defer c.Close()
+		subscribed = append(subscribed, ch)
+	}
+	c, other := subscribeClient(t, addr, "/other")
+	defer c.Close()
+
+	for _, publish := range []struct {
+		name string
+		fn   func(string, json.RawMessage, []byte) error
+	}{
+		{"PublishMessage", s.PublishMessage},
+		{"QuickPublishMessage", s.QuickPublishMessage},
+	} {
+		if err := publish.fn("/m", json.RawMessage(`{"n":1}`), []byte(publish.name)); err != nil {
+			t.Fatalf("%s: %v", publish.name, err)
+		}
+		for i, ch := range subscribed {
+			select {
+			case m := <-ch:
+				if string(m.URL) != "/m" || string(m.Param) != `{"n":1}` || string(m.Data) != publish.name {
+					t.Fatalf("%s: subscriber %d got %s %s %q", publish.name, i, m.URL, m.Param, m.Data)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s: subscriber %d got no publish", publish.name, i)
+			}
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for i, ch := range subscribed {
+		if n := len(ch); n != 0 {
+			t.Fatalf("subscriber %d got %d more publishes", i, n)
+		}
+	}
+	if n := len(other); n != 0 {
+		t.Fatalf("subscriber of /other got %d publishes", n)
+	}
+}
+
+func TestUnpublish(t *testing.T) {
+	s := NewServer("test", Option{AutoAuth: true})
+	s.RegisterPublishURL("/a/b")
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	// QuickPublish without leading slash is stopped by Unpublish
+	if err := s.QuickPublish("q", time.Hour, func(req, res *protocol.Message) {}); err != nil {
+		t.Fatalf("quick publish: %v", err)
+	}
+	if err := s.Unpublish("q"); err != nil {
+		t.Fatalf("unpublish q: %v", err)
+	}
+	s.router.mu.Lock()
+	running := len(s.pubStops)
+	s.router.mu.Unlock()
+	if running != 0 {
+		t.Fatalf("%d publishers running after Unpublish", running)
+	}
+
+	// exact and parent URL subscribers are told
+	var unsubscribed []chan *protocol.Message
+	for _, URL := range []string{"/a/b", "/a/", "/"} {
+		messages := make(chan *protocol.Message, 1)
+		c := vsoaclient.NewClient(vsoaclient.Option{})
+		c.ServerMessageChan = messages
+		if _, err := c.Connect("vsoa", addr); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer c.Close()
+		if err := c.Subscribe(URL, nil); err != nil {
+			t.Fatalf("subscribe %s: %v", URL, err)
+		}
+		unsubscribed = append(unsubscribed, messages)
+	}
+
+	if err := s.Unpublish("/a/b"); err != nil {
+		t.Fatalf("unpublish /a/b: %v", err)
+	}
+	for i, messages := range unsubscribed {
+		select {
+		case m := <-messages:
+			if !m.IsUnSubscribe() || string(m.URL) != "/a/b" {
+				t.Fatalf("subscriber %d got %s %s, want UNSUBSCRIBE /a/b", i, m.MessageTypeText(), m.URL)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("subscriber %d was not told", i)
+		}
+	}
+}
+
+func TestTriggerPublisher(t *testing.T) {
+	s := NewServer("test", Option{AutoAuth: true})
+	trigger := make(chan struct{})
+	if err := s.Publish("/raw", trigger, func(req, res *protocol.Message) {
+		req.Data = []byte("raw")
+	}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	c, publishes := subscribeClient(t, addr, "/raw")
+	defer c.Close()
+
+	if err := s.TriggerPublisher("/raw"); err != nil {
+		t.Fatalf("trigger: %v", err)
+	}
+	select {
+	case m := <-publishes:
+		if string(m.Data) != "raw" {
+			t.Fatalf("got %q, want raw", m.Data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no publish after trigger")
+	}
+
+	// the publisher is gone after Shutdown, triggering must not hang
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.TriggerPublisher("/raw") }()
+	select {
+	case err := <-done:
+		if err != ErrServerClosed {
+			t.Fatalf("trigger after shutdown: got %v, want ErrServerClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("trigger after shutdown hangs")
+	}
+
+	if err := s.TriggerPublisher("/none"); err != ErrNilPublishHandler {
+		t.Fatalf("trigger unknown URL: got %v, want ErrNilPublishHandler", err)
+	}
+}
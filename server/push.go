@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// SendTo sends msg to one client on the normal channel.
+// msg is sent as a server request (not a reply), client receives it
+// from Client.ServerMessageChan.
+//
+// msg is queued to the client write queue like a publish, so the queue
+// overflow policy may drop it: ErrWriteQueueFull is returned if msg is
+// not queued, and with DropOldest a queued msg may still be dropped later
+// for a newer frame, after SendTo returned nil.
+func (s *Server) SendTo(clientUid uint32, msg *protocol.Message) error {
+	if _, err := s.activeClient(clientUid); err != nil {
+		return err
+	}
+
+	msg.SetReply(false)
+
+	tmp, err := msg.Encode(protocol.ChannelNormal)
+	if err != nil {
+		return err
+	}
+
+	return s.enqueueTo(clientUid, tmp, true)
+}
+
+// DatagramTo sends msg to one client as a DATAGRAM, on the quick channel
+// if quick is protocol.ChannelQuick.
+// Client receives it from Client.ServerMessageChan.
+//
+// On the normal channel msg is queued like SendTo and may be dropped the
+// same way, on the quick channel it is sent at once and may be lost
+// like any UDP packet.
+func (s *Server) DatagramTo(clientUid uint32, msg *protocol.Message, quick protocol.QuickChannelFlag) error {
+	if !quick {
+		msg.SetMessageType(protocol.TypeDatagram)
+		msg.SetSeqNo(0)
+		return s.SendTo(clientUid, msg)
+	}
+
+	c, err := s.activeClient(clientUid)
+	if err != nil {
+		return err
+	}
+	if c.QAddr == nil || s.qln == nil {
+		return ErrNoQuickChannel
+	}
+
+	msg.SetMessageType(protocol.TypeDatagram)
+	msg.SetReply(false)
+	msg.SetSeqNo(0)
+
+	tmp, err := msg.Encode(protocol.ChannelQuick)
+	if err != nil {
+		return err
+	}
+
+	err = s.qsendMessage(tmp, c)
+	protocol.PutData(&tmp)
+	return err
+}
+
+// activeClient returns an active client.
+func (s *Server) activeClient(clientUid uint32) (*client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.clients[clientUid]
+	if !ok || !c.Active || c.Conn == nil {
+		return nil, ErrClientNotFound
+	}
+	return c, nil
+}
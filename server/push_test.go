@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+func TestSendTo(t *testing.T) {
+	s := NewServer("test", Option{})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+	waitQuickListener(t, s)
+
+	var clients []*vsoaclient.Client
+	var messages []chan *protocol.Message
+	for i := 0; i < 2; i++ {
+		ch := make(chan *protocol.Message, 4)
+		c := vsoaclient.NewClient(vsoaclient.Option{})
+		c.ServerMessageChan = ch
+		if _, err := c.Connect("vsoa", addr); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+		messages = append(messages, ch)
+	}
+	target := clients[0].GetUid()
+
+	tests := []struct {
+		name string
+		send func(msg *protocol.Message) error
+		typ  protocol.MessageType
+	}{
+		{"SendTo", func(msg *protocol.Message) error {
+			msg.SetMessageType(protocol.TypeRPC)
+			return s.SendTo(target, msg)
+		}, protocol.TypeRPC},
+		{"DatagramTo normal", func(msg *protocol.Message) error {
+			return s.DatagramTo(target, msg, protocol.ChannelNormal)
+		}, protocol.TypeDatagram},
+		{"DatagramTo quick", func(msg *protocol.Message) error {
+			return s.DatagramTo(target, msg, protocol.ChannelQuick)
+		}, protocol.TypeDatagram},
+	}
+	for _, tt := range tests {
+		msg := protocol.NewMessage()
+		msg.URL = []byte("/to")
+		msg.Data = []byte(tt.name)
+		if err := tt.send(msg); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		select {
+		case m := <-messages[0]:
+			if m.MessageType() != tt.typ || string(m.URL) != "/to" || string(m.Data) != tt.name {
+				t.Fatalf("%s: got %s %s %q", tt.name, m.MessageTypeText(), m.URL, m.Data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: target got nothing", tt.name)
+		}
+	}
+	select {
+	case m := <-messages[1]:
+		t.Fatalf("other client got %s %s %q", m.MessageTypeText(), m.URL, m.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a connection before ServInfo is not active yet
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	var unauthed uint32
+	for deadline := time.Now().Add(2 * time.Second); unauthed == 0; time.Sleep(10 * time.Millisecond) {
+		s.mu.RLock()
+		for uid, c := range s.clients {
+			if !c.Active {
+				unauthed = uid
+			}
+		}
+		s.mu.RUnlock()
+		if time.Now().After(deadline) {
+			t.Fatal("server did not accept the connection")
+		}
+	}
+	for _, uid := range []uint32{unauthed, 1000} {
+		if err := s.SendTo(uid, protocol.NewMessage()); err != ErrClientNotFound {
+			t.Fatalf("SendTo %d: got %v, want ErrClientNotFound", uid, err)
+		}
+		if err := s.DatagramTo(uid, protocol.NewMessage(), protocol.ChannelQuick); err != ErrClientNotFound {
+			t.Fatalf("DatagramTo %d: got %v, want ErrClientNotFound", uid, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// pubLimiter is a token bucket used to cap publishes sent to one client.
+type pubLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newPubLimiter(rate int) *pubLimiter {
+	return &pubLimiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// allow reports whether one more publish can be sent now.
+func (l *pubLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	// Burst is one second of publishes
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// qosSetupHandler handles the QoS setup request from a client.
+//
+// The settings are checked against VSOA limits and HandleQosSetup, then
+// applied to the client connection. The accepted settings are echoed back
+// in the reply Param.
+func (s *Server) qosSetupHandler(req *protocol.Message, res *protocol.Message, ClientUid uint32) {
+	qos, err := protocol.DecodeQosSetup(req.Param)
+	if err != nil {
+		res.SetStatusType(protocol.StatusArguments)
+		return
+	}
+	if err = qos.Validate(); err != nil {
+		res.SetStatusType(protocol.StatusArguments)
+		return
+	}
+
+	if s.HandleQosSetup != nil {
+		if status := s.HandleQosSetup(ClientUid, &qos); status != protocol.StatusSuccess {
+			res.SetStatusType(status)
+			return
+		}
+		// Handler may change the settings, so check again
+		if err = qos.Validate(); err != nil {
+			res.SetStatusType(protocol.StatusArguments)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	c, ok := s.clients[ClientUid]
+	if !ok {
+		s.mu.Unlock()
+		res.SetStatusType(protocol.StatusNoResponding)
+		return
+	}
+	c.Qos = qos
+	if qos.PublishRate > 0 {
+		c.pubLimiter = newPubLimiter(qos.PublishRate)
+	} else {
+		c.pubLimiter = nil
+	}
+	conn := c.Conn
+	s.mu.Unlock()
+
+	if err = applyConnQos(conn, qos); err != nil {
+		log.Printf("Vsoa client[%d] QoS socket setup failed: %v", ClientUid, err)
+	}
+
+	qos.NewMessage(res)
+	res.SetReply(true)
+	res.SetStatusType(protocol.StatusSuccess)
+}
+
+// applyConnQos sets socket buffers and traffic priority of the client normal channel.
+func applyConnQos(conn net.Conn, qos protocol.QosSetupParam) (err error) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if qos.SendBuffer != 0 {
+		if err = tcpConn.SetWriteBuffer(qos.SendBuffer); err != nil {
+			return err
+		}
+	}
+	if qos.RecvBuffer != 0 {
+		if err = tcpConn.SetReadBuffer(qos.RecvBuffer); err != nil {
+			return err
+		}
+	}
+	if qos.Priority != 0 {
+		return setConnPriority(tcpConn, qos.Priority)
+	}
+	return nil
+}
+
+// pubCandidate is a client to publish to, with its QoS publish limiter
+// copied under the server lock.
+type pubCandidate struct {
+	c       *client
+	limiter *pubLimiter
+}
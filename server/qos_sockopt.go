@@ -0,0 +1,28 @@
+//go:build linux || darwin || freebsd
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// setConnPriority maps QoS priority to IP precedence bits of the TOS field.
+func setConnPriority(conn *net.TCPConn, priority int) (err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	cerr := raw.Control(func(fd uintptr) {
+		if ip, ok := conn.LocalAddr().(*net.TCPAddr); ok && ip.IP.To4() == nil {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, priority<<5)
+		} else {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, priority<<5)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package server
+
+import "net"
+
+// setConnPriority is not supported on this platform, QoS priority has
+// no effect.
+func setConnPriority(_ *net.TCPConn, _ int) error {
+	return nil
+}
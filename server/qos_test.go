@@ -0,0 +1,123 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+func TestQosSetup(t *testing.T) {
+	s := NewServer("test", Option{})
+	s.HandleQosSetup = func(clientUid uint32, qos *protocol.QosSetupParam) protocol.StatusType {
+		if qos.Priority > 5 {
+			return protocol.StatusNoPermissions
+		}
+		// the server caps the publish rate
+		if qos.PublishRate > 100 {
+			qos.PublishRate = 100
+		}
+		return protocol.StatusSuccess
+	}
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	accepted, err := c.QosSetup(protocol.QosSetupParam{Priority: 3, SendBuffer: 64 * 1024, PublishRate: 1000})
+	if err != nil {
+		t.Fatalf("QosSetup: %v", err)
+	}
+	want := protocol.QosSetupParam{Priority: 3, SendBuffer: 64 * 1024, PublishRate: 100}
+	if accepted != want {
+		t.Fatalf("accepted %+v, want %+v", accepted, want)
+	}
+
+	s.mu.RLock()
+	got := s.clients[c.GetUid()].Qos
+	s.mu.RUnlock()
+	if got != want {
+		t.Fatalf("server applied %+v, want %+v", got, want)
+	}
+
+	// rejected by HandleQosSetup
+	_, err = c.QosSetup(protocol.QosSetupParam{Priority: 6})
+	if want := protocol.StatusText(protocol.StatusNoPermissions); err == nil || err.Error() != want {
+		t.Fatalf("rejected QosSetup: got %v, want %s", err, want)
+	}
+
+	// out of VSOA limits, sent raw since QosSetup checks it first
+	req := protocol.NewMessage()
+	protocol.QosSetupParam{Priority: protocol.MaxQosPriority + 1}.NewMessage(req)
+	_, err = c.Call("", protocol.TypeQosSetup, nil, req)
+	if want := protocol.StatusText(protocol.StatusArguments); err == nil || err.Error() != want {
+		t.Fatalf("invalid QosSetup: got %v, want %s", err, want)
+	}
+	if _, err = c.QosSetup(protocol.QosSetupParam{SendBuffer: 1}); err != protocol.ErrQosBufferSize {
+		t.Fatalf("QosSetup checks limits: got %v, want ErrQosBufferSize", err)
+	}
+}
+
+func TestQosPublishRate(t *testing.T) {
+	s := NewServer("test", Option{AutoAuth: true})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	received := make(chan struct{}, 100)
+	connect := func(rate int) *vsoaclient.Client {
+		c := vsoaclient.NewClient(vsoaclient.Option{})
+		if _, err := c.Connect("vsoa", addr); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		if rate != 0 {
+			if _, err := c.QosSetup(protocol.QosSetupParam{PublishRate: rate}); err != nil {
+				t.Fatalf("QosSetup: %v", err)
+			}
+		}
+		return c
+	}
+
+	trigger := make(chan struct{}, 1)
+	if err := s.Publish("/rate", trigger, func(req, res *protocol.Message) {
+		req.Data = []byte("x")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	limited := connect(2)
+	defer limited.Close()
+	if err := limited.Subscribe("/rate", func(m *protocol.Message) {
+		received <- struct{}{}
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	unlimited := connect(0)
+	defer unlimited.Close()
+	all := make(chan struct{}, 100)
+	if err := unlimited.Subscribe("/rate", func(m *protocol.Message) {
+		all <- struct{}{}
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		trigger <- struct{}{}
+		select {
+		case <-all:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("unlimited client got %d publishes, want %d", i, n)
+		}
+	}
+	// a burst is one second of publishes
+	time.Sleep(100 * time.Millisecond)
+	if got := len(received); got < 2 || got > 3 {
+		t.Fatalf("client limited to 2 publishes/s got %d of %d publishes", got, n)
+	}
+}
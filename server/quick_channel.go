@@ -8,6 +8,7 @@ import (
 	"net"
 	"runtime"
 	"strings"
+	"sync/atomic"
 
 	"github.com/acoinfo/vsoa/protocol"
 )
@@ -16,21 +17,24 @@ import (
 //
 // It takes an address string as a parameter and returns an error.
 func (s *Server) serveQuickListener(_ string) (err error) {
-	qAddrServer := (*net.UDPAddr)(s.ln.Addr().(*net.TCPAddr))
-	s.qln, err = net.ListenUDP("udp", qAddrServer)
+	s.mu.RLock()
+	ln := s.ln
+	s.mu.RUnlock()
+
+	qAddrServer := (*net.UDPAddr)(ln.Addr().(*net.TCPAddr))
+	qln, err := net.ListenUDP("udp", qAddrServer)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if s.qln != nil {
-			_ = s.qln.Close()
-		}
-	}()
+	s.mu.Lock()
+	s.qln = qln
+	s.mu.Unlock()
+	defer qln.Close()
 
 	buf := make([]byte, 1024)
 
 	for {
-		n, addr, err := s.qln.ReadFromUDP(buf)
+		n, addr, err := qln.ReadFromUDP(buf)
 		if err != nil {
 			if s.IsShutdown() || errors.Is(err, net.ErrClosed) ||
 				strings.Contains(err.Error(), "use of closed network connection") {
@@ -46,30 +50,50 @@ func (s *Server) serveQuickListener(_ string) (err error) {
 		}
 
 		qAddr := addr.String()
-		if clientUid, ok := s.quickChannel[qAddr]; ok {
-			if client, ok := s.clients[clientUid]; ok {
-				if client.Active {
-					req := protocol.NewMessage()
-					r := bytes.NewBuffer(buf[:n])
-					err = req.Decode(r)
-					if err != nil {
-						if errors.Is(err, io.EOF) {
-							if s.HandleServiceError == nil {
-								log.Printf("Vsoa client[%d] has closed this connection: %s", clientUid, qAddr)
-							}
-						}
+		s.mu.RLock()
+		clientUid, ok := s.quickChannel[qAddr]
+		client, found := s.clients[clientUid]
+		if !ok || !found || !client.Active {
+			s.mu.RUnlock()
+			continue
+		}
+		quickCipher := client.quickCipher
+		quickSigner := client.quickSigner
+		s.mu.RUnlock()
 
-						if s.HandleServiceError != nil {
-							s.HandleServiceError(clientUid, err)
-						}
-						return err
-					}
-					go s.processOneQuickRequest(req, clientUid)
+		packet := buf[:n]
+		if quickCipher != nil {
+			// forged, replayed and plaintext packets are dropped
+			if packet, err = quickCipher.Open(packet); err != nil {
+				continue
+			}
+		} else if quickSigner != nil {
+			// spoofed, replayed and unsigned packets are dropped
+			if packet, err = quickSigner.Verify(packet); err != nil {
+				continue
+			}
+		}
+		req := protocol.NewMessage()
+		r := bytes.NewBuffer(packet)
+		err = req.Decode(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				if s.HandleServiceError == nil {
+					log.Printf("Vsoa client[%d] has closed this connection: %s", clientUid, qAddr)
 				}
 			}
-		} else {
+
+			if s.HandleServiceError != nil {
+				s.HandleServiceError(clientUid, err)
+			}
+			// one bad packet must not stop the quick channel of all clients
 			continue
 		}
+		if quickSigner != nil && req.SeqNo() != clientUid {
+			continue
+		}
+		atomic.AddInt32(&s.handlerMsgNum, 1)
+		go s.processOneQuickRequest(req, clientUid)
 	}
 
 }
@@ -78,7 +102,7 @@ func (s *Server) serveQuickListener(_ string) (err error) {
 //
 // It takes in a req of type *protocol.Message and ClientUid of type uint32.
 // It does not return anything.
-func (s *Server) processOneQuickRequest(req *protocol.Message, _ uint32) {
+func (s *Server) processOneQuickRequest(req *protocol.Message, ClientUid uint32) {
 	defer func() {
 		if r := recover(); r != nil {
 			buf := make([]byte, 1024)
@@ -87,12 +111,14 @@ func (s *Server) processOneQuickRequest(req *protocol.Message, _ uint32) {
 			log.Printf("failed to handle the request: %v, stacks: %s", r, buf)
 		}
 	}()
+	defer atomic.AddInt32(&s.handlerMsgNum, -1)
+
+	if !s.authorize(ClientUid, OpDatagram, string(req.URL)) {
+		return
+	}
 
 	res := protocol.NewMessage()
 
-	if sh, ok := s.routeMap["DATAGRAME."+string(req.URL)]; ok {
-		sh.handler(req, res)
-	} else if sh, ok := s.routeMap["DATAGRAME.DEFAULT"]; ok {
-		sh.handler(req, res)
-	}
+	entry, params := s.router.lookupDatagram(string(req.URL))
+	s.callHandler(entry, params, ClientUid, req, res)
 }
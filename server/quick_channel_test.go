@@ -0,0 +1,89 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+func TestQuickAuth(t *testing.T) {
+	s := NewServer("test", Option{QuickAuth: true})
+	received := make(chan string, 4)
+	s.OnDatagram("/quick", func(req, res *protocol.Message) {
+		received <- string(req.Data)
+	})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+	waitQuickListener(t, s)
+
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	// unsigned packet from the registered address
+	spoofed := protocol.NewMessage()
+	spoofed.SetMessageType(protocol.TypeDatagram)
+	spoofed.SetSeqNo(c.GetUid())
+	spoofed.URL = []byte("/quick")
+	spoofed.Data = []byte("spoofed")
+	frame, _ := spoofed.Encode(protocol.ChannelQuick)
+	if _, err := c.QConn.Write(frame); err != nil {
+		t.Fatalf("write spoofed packet: %v", err)
+	}
+
+	// a packet signed with the token of c is taken once, then it is a replay
+	s.mu.RLock()
+	signer := protocol.NewQuickSigner(s.clients[c.GetUid()].quickToken)
+	s.mu.RUnlock()
+	replayed := protocol.NewMessage()
+	replayed.SetMessageType(protocol.TypeDatagram)
+	replayed.SetSeqNo(c.GetUid())
+	replayed.URL = []byte("/quick")
+	replayed.Data = []byte("replayed")
+	frame, _ = replayed.Encode(protocol.ChannelQuick)
+	packet := signer.Sign(frame)
+	for i := 0; i < 2; i++ {
+		if _, err := c.QConn.Write(packet); err != nil {
+			t.Fatalf("write replayed packet: %v", err)
+		}
+	}
+	// signed packet that is not a frame
+	if _, err := c.QConn.Write(signer.Sign([]byte("garbage"))); err != nil {
+		t.Fatalf("write bad packet: %v", err)
+	}
+
+	// the quick channel still works for other clients
+	c2 := vsoaclient.NewClient(vsoaclient.Option{})
+	if _, err := c2.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c2.Close()
+	req := protocol.NewMessage()
+	req.Data = []byte("signed")
+	if _, err := c2.Call("/quick", protocol.TypeDatagram, protocol.ChannelQuick, req); err != nil {
+		t.Fatalf("quick datagram: %v", err)
+	}
+
+	got := map[string]int{}
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case data := <-received:
+			got[data]++
+		case <-timeout:
+			t.Fatalf("server got %v, want replayed and signed datagrams", got)
+		}
+	}
+	select {
+	case data := <-received:
+		got[data]++
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got["replayed"] != 1 || got["signed"] != 1 || len(got) != 2 {
+		t.Fatalf("server got %v, want replayed and signed datagrams once", got)
+	}
+}
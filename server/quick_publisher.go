@@ -2,62 +2,65 @@ package server
 
 import (
 	"log"
-	"net"
 	"time"
 
 	"github.com/acoinfo/vsoa/protocol"
 )
 
-func (s *Server) qpublisher(servicePath string, timeOrTrigger any, pubs func(*protocol.Message, *protocol.Message)) {
+func (s *Server) qpublisher(servicePath string, timeOrTrigger any, filter ClientFilter, pubs func(*protocol.Message, *protocol.Message), stop <-chan struct{}) {
 	req := protocol.NewMessage()
 
-	var ticker *time.Ticker
-	isTrigger := false
+	var tick <-chan time.Time
+	var trigger chan struct{}
 
 	switch v := timeOrTrigger.(type) {
 	case time.Duration:
-		ticker = time.NewTicker(v)
+		ticker := time.NewTicker(v)
 		defer ticker.Stop()
+		tick = ticker.C
 	case chan struct{}:
-		s.triggerChan[servicePath] = v
-		isTrigger = true
+		trigger = v
 	default:
 		panic("Invalid type for timeOrTrigger")
 	}
 
 	for {
-		if isTrigger {
-			<-s.triggerChan[servicePath]
-		} else {
-			<-ticker.C
+		select {
+		case <-tick:
+		case <-trigger:
+		case <-stop:
+			return
+		case <-s.pubStop:
+			return
 		}
 
 		pubs(req, nil)
 
-		for _, client := range s.clients {
-			if client.Subscribes[servicePath] && client.Authed {
-				//PUT URL into req otherwise client will not receive this publish
-				req.URL = []byte(servicePath)
-				go s.qsendMessage(req, client.QAddr)
+		//PUT URL into req otherwise client will not receive this publish
+		frame, err := encodePublish(req, servicePath, protocol.ChannelQuick)
+		if err != nil {
+			log.Println("Error encoding quick publish:", err)
+			continue
+		}
+
+		for _, client := range s.subscribersOf(servicePath, filter) {
+			if client.QAddr != nil {
+				s.qsendMessage(frame, client)
 			}
 		}
 	}
 }
 
-// Normal channel Publish Message
-func (s *Server) qsendMessage(req *protocol.Message, qAddr *net.UDPAddr) error {
-	req.SetMessageType(protocol.TypePublish)
-
-	req.SetReply(false)
-
-	tmp, err := req.Encode(protocol.ChannelNormal)
-	if err != nil {
-		log.Panicln(err)
-		return err
+// Quick channel Publish Message
+// frame is sealed first if the quick channel of c is sealed.
+func (s *Server) qsendMessage(frame []byte, c *client) error {
+	if s.qln == nil {
+		return ErrServerClosed
 	}
 
-	s.qln.WriteToUDP(tmp, qAddr)
-	protocol.PutData(&tmp)
-
+	if c.quickCipher != nil {
+		frame = c.quickCipher.Seal(frame)
+	}
+	_, err := s.qln.WriteToUDP(frame, c.QAddr)
 	return err
 }
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// newQuickCipher returns the cipher sealing the quick channel of a client
+// with the ServInfo param, nil if the quick channel is plaintext.
+// allowed is false if the client must not use the quick channel.
+func (s *Server) newQuickCipher(param *protocol.ServInfoReqParam, ClientUid uint32) (qc *protocol.QuickCipher, allowed bool) {
+	s.mu.RLock()
+	conn := s.clients[ClientUid].Conn
+	s.mu.RUnlock()
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, true
+	}
+
+	if param.QuickAEAD {
+		qc, err := protocol.NewQuickCipher(tlsConn, true)
+		if err == nil {
+			return qc, true
+		}
+		log.Printf("Vsoa client[%d] quick channel keys: %v", ClientUid, err)
+	}
+	return nil, !s.option.QuickEncryption
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+var (
+	ErrParamConflict = errors.New("URL parameter name conflicts with registered route")
+)
+
+// Param is a single URL parameter, consisting of a key and a value.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params is a Param-slice, as returned by the router.
+type Params []Param
+
+// ByName returns the value of the first Param which key matches the given name.
+// If no matching Param is found, an empty string is returned.
+func (ps Params) ByName(name string) string {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// routeEntry is a registered route pattern with its handler.
+type routeEntry struct {
+	pattern string
+	serverHandler
+}
+
+// routeNode is one URL segment of the route trie.
+//
+// A pattern without trailing slash is saved in exact, and only matches
+// the same URL. A pattern with trailing slash is saved in prefix, and
+// matches the URL and all URLs under it.
+type routeNode struct {
+	children  map[string]*routeNode // static segments
+	param     *routeNode            // ":name" segment
+	paramName string
+	exact     *routeEntry
+	prefix    *routeEntry
+}
+
+// routeTree is a URL segment trie shared by RPC, DATAGRAM and SUBS lookups.
+// Static segments take precedence over ":name" segments, and the longest
+// matched route wins.
+type routeTree struct {
+	root routeNode
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func isPrefixPattern(pattern string) bool {
+	return strings.HasSuffix(pattern, "/")
+}
+
+// node returns the node of pattern, if create is true missing nodes are created.
+func (t *routeTree) node(pattern string, create bool) (*routeNode, error) {
+	n := &t.root
+	for _, seg := range splitPath(pattern) {
+		if strings.HasPrefix(seg, ":") {
+			if n.param == nil {
+				if !create {
+					return nil, nil
+				}
+				n.param = &routeNode{paramName: seg[1:]}
+			} else if n.param.paramName != seg[1:] {
+				return nil, ErrParamConflict
+			}
+			n = n.param
+			continue
+		}
+
+		child, ok := n.children[seg]
+		if !ok {
+			if !create {
+				return nil, nil
+			}
+			if n.children == nil {
+				n.children = make(map[string]*routeNode)
+			}
+			child = new(routeNode)
+			n.children[seg] = child
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// add registers handler with pattern.
+func (t *routeTree) add(pattern string, sh serverHandler) error {
+	n, err := t.node(pattern, true)
+	if err != nil {
+		return err
+	}
+
+	entry := &routeEntry{pattern: pattern, serverHandler: sh}
+	if isPrefixPattern(pattern) {
+		if n.prefix != nil {
+			return ErrAlreadyRegistered
+		}
+		n.prefix = entry
+	} else {
+		if n.exact != nil {
+			return ErrAlreadyRegistered
+		}
+		n.exact = entry
+	}
+	return nil
+}
+
+// remove unregisters the route registered with exactly the same pattern,
+// and prunes the nodes left without routes. It returns the removed route.
+func (t *routeTree) remove(pattern string) *routeEntry {
+	segs := splitPath(pattern)
+
+	var entry *routeEntry
+	// walk returns true if n has nothing left after the removal
+	var walk func(n *routeNode, depth int) bool
+	walk = func(n *routeNode, depth int) bool {
+		if depth == len(segs) {
+			if isPrefixPattern(pattern) {
+				entry, n.prefix = n.prefix, nil
+			} else {
+				entry, n.exact = n.exact, nil
+			}
+		} else if seg := segs[depth]; strings.HasPrefix(seg, ":") {
+			if n.param == nil || n.param.paramName != seg[1:] {
+				return false
+			}
+			if walk(n.param, depth+1) {
+				n.param = nil
+			}
+		} else {
+			child, ok := n.children[seg]
+			if !ok {
+				return false
+			}
+			if walk(child, depth+1) {
+				delete(n.children, seg)
+			}
+		}
+		return n.exact == nil && n.prefix == nil && n.param == nil && len(n.children) == 0
+	}
+	walk(&t.root, 0)
+
+	return entry
+}
+
+// get returns the route registered with exactly the same pattern.
+func (t *routeTree) get(pattern string) *routeEntry {
+	n, _ := t.node(pattern, false)
+	if n == nil {
+		return nil
+	}
+	if isPrefixPattern(pattern) {
+		return n.prefix
+	}
+	return n.exact
+}
+
+// lookup returns the best matched route for URL and the URL parameters.
+func (t *routeTree) lookup(URL string) (*routeEntry, Params) {
+	segs := splitPath(URL)
+	// URL with trailing slash only matches prefix routes
+	exactAllowed := !isPrefixPattern(URL) || len(segs) == 0
+
+	var m routeMatch
+	m.search(&t.root, segs, 0, exactAllowed, nil)
+	return m.entry, m.params
+}
+
+// routeMatch keeps the best route found during trie search.
+type routeMatch struct {
+	entry  *routeEntry
+	params Params
+	score  int
+}
+
+// search walks the trie depth first. A route matched with more segments
+// has higher score, and an exact route beats the prefix route on the same node.
+func (m *routeMatch) search(n *routeNode, segs []string, depth int, exactAllowed bool, params Params) {
+	if n.prefix != nil {
+		m.offer(n.prefix, 2*depth+1, params)
+	}
+
+	if depth == len(segs) {
+		if n.exact != nil && exactAllowed {
+			m.offer(n.exact, 2*depth+2, params)
+		}
+		return
+	}
+
+	if child, ok := n.children[segs[depth]]; ok {
+		m.search(child, segs, depth+1, exactAllowed, params)
+	}
+	if n.param != nil && segs[depth] != "" {
+		m.search(n.param, segs, depth+1, exactAllowed,
+			append(params[:len(params):len(params)], Param{Key: n.param.paramName, Value: segs[depth]}))
+	}
+}
+
+func (m *routeMatch) offer(entry *routeEntry, score int, params Params) {
+	if m.entry != nil && score <= m.score {
+		return
+	}
+	m.entry = entry
+	m.score = score
+	if len(params) == 0 {
+		m.params = nil
+	} else {
+		m.params = append(Params(nil), params...)
+	}
+}
+
+// under returns all routes registered under the prefix pattern, itself included.
+func (t *routeTree) under(prefix string) []*routeEntry {
+	n, _ := t.node(prefix, false)
+	if n == nil {
+		return nil
+	}
+
+	var entries []*routeEntry
+	if n.prefix != nil {
+		entries = append(entries, n.prefix)
+	}
+	var walk func(n *routeNode)
+	walk = func(n *routeNode) {
+		for _, child := range n.children {
+			if child.exact != nil {
+				entries = append(entries, child.exact)
+			}
+			if child.prefix != nil {
+				entries = append(entries, child.prefix)
+			}
+			walk(child)
+		}
+		if n.param != nil {
+			if n.param.exact != nil {
+				entries = append(entries, n.param.exact)
+			}
+			if n.param.prefix != nil {
+				entries = append(entries, n.param.prefix)
+			}
+			walk(n.param)
+		}
+	}
+	walk(n)
+	return entries
+}
+
+// router holds all server routes.
+type router struct {
+	mu              sync.RWMutex
+	rpc             [2]routeTree // indexed by protocol.RpcMessageType
+	datagram        routeTree
+	subs            routeTree
+	datagramDefault *routeEntry
+}
+
+func (r *router) rpcTree(method protocol.RpcMessageType) *routeTree {
+	if method == protocol.RpcMethodSet {
+		return &r.rpc[protocol.RpcMethodSet]
+	}
+	return &r.rpc[protocol.RpcMethodGet]
+}
+
+// lookupRPC finds the RPC route matched with URL.
+func (r *router) lookupRPC(method protocol.RpcMessageType, URL string) (*routeEntry, Params) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.rpcTree(method).lookup(URL)
+}
+
+// lookupDatagram finds the DATAGRAM route matched with URL, falls back to
+// the default DATAGRAM handler.
+func (r *router) lookupDatagram(URL string) (*routeEntry, Params) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if entry, params := r.datagram.lookup(URL); entry != nil {
+		return entry, params
+	}
+	return r.datagramDefault, nil
+}
+
+// getPublish returns the publish route registered with servicePath.
+func (r *router) getPublish(servicePath string) *routeEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.subs.get(servicePath)
+}
+
+// Params returns the URL parameters matched by the route of req.
+// It is only valid inside the handler of req.
+func (s *Server) Params(req *protocol.Message) Params {
+	if ctx := s.Context(req); ctx != nil {
+		return ctx.Params
+	}
+	return nil
+}
+
+// callHandler calls the route handler of req wrapped by middleware, and
+// makes the request context available to the handler.
+func (s *Server) callHandler(entry *routeEntry, params Params, clientUid uint32, req, res *protocol.Message) {
+	if entry == nil || entry.handler == nil {
+		return
+	}
+
+	ctx := s.newContext(clientUid, params)
+	s.requests.Store(req, ctx)
+	defer s.requests.Delete(req)
+
+	s.chain(entry.group, entry.handler)(req, res)
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+func TestRouteTreeLookup(t *testing.T) {
+	var tree routeTree
+
+	patterns := []string{
+		"/",
+		"/a/b/c",
+		"/a/b/c/",
+		"/a/",
+		"/vehicle/:id/speed",
+		"/vehicle/list",
+		"/vehicle/:id/",
+	}
+	for _, p := range patterns {
+		if err := tree.add(p, serverHandler{}); err != nil {
+			t.Fatalf("add %q: %v", p, err)
+		}
+	}
+
+	tests := []struct {
+		url     string
+		pattern string
+		id      string
+	}{
+		{"/a/b/c", "/a/b/c", ""},
+		{"/a/b/c/d", "/a/b/c/", ""},
+		{"/a/b/c/", "/a/b/c/", ""},
+		{"/a/b", "/a/", ""},
+		{"/a", "/a/", ""},
+		{"/x/y", "/", ""},
+		{"", "/", ""},
+		{"/vehicle/list", "/vehicle/list", ""},
+		{"/vehicle/42/speed", "/vehicle/:id/speed", "42"},
+		{"/vehicle/42/gear", "/vehicle/:id/", "42"},
+		{"/vehicle/list/speed", "/vehicle/:id/speed", "list"},
+	}
+	for _, tt := range tests {
+		entry, params := tree.lookup(tt.url)
+		if entry == nil {
+			t.Fatalf("lookup %q: no route", tt.url)
+		}
+		if entry.pattern != tt.pattern {
+			t.Errorf("lookup %q: got %q, want %q", tt.url, entry.pattern, tt.pattern)
+		}
+		if got := params.ByName("id"); got != tt.id {
+			t.Errorf("lookup %q: got id %q, want %q", tt.url, got, tt.id)
+		}
+	}
+}
+
+func TestRouteTreeConflicts(t *testing.T) {
+	var tree routeTree
+
+	if err := tree.add("/a/:id", serverHandler{}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := tree.add("/a/:id", serverHandler{}); err != ErrAlreadyRegistered {
+		t.Fatalf("expected ErrAlreadyRegistered, got %v", err)
+	}
+	if err := tree.add("/a/:name/b", serverHandler{}); err != ErrParamConflict {
+		t.Fatalf("expected ErrParamConflict, got %v", err)
+	}
+	if entry, _ := tree.lookup("/b"); entry != nil {
+		t.Fatalf("expected no route for /b, got %q", entry.pattern)
+	}
+}
+
+func TestRouteTreeRemove(t *testing.T) {
+	var tree routeTree
+
+	for _, p := range []string{"/a/", "/a/:id", "/a/:id/b"} {
+		if err := tree.add(p, serverHandler{}); err != nil {
+			t.Fatalf("add %q: %v", p, err)
+		}
+	}
+
+	if entry := tree.remove("/a/:name"); entry != nil {
+		t.Fatalf("removed %q with other parameter name", entry.pattern)
+	}
+	if entry := tree.remove("/a/:id"); entry == nil || entry.pattern != "/a/:id" {
+		t.Fatalf("remove /a/:id: got %v", entry)
+	}
+	if entry, _ := tree.lookup("/a/1"); entry == nil || entry.pattern != "/a/" {
+		t.Fatalf("lookup /a/1 after remove: got %v", entry)
+	}
+	if entry, _ := tree.lookup("/a/1/b"); entry == nil || entry.pattern != "/a/:id/b" {
+		t.Fatalf("lookup /a/1/b after remove: got %v", entry)
+	}
+
+	// The parameter node is pruned with its last route
+	if entry := tree.remove("/a/:id/b"); entry == nil {
+		t.Fatal("remove /a/:id/b: no route")
+	}
+	if err := tree.add("/a/:name", serverHandler{}); err != nil {
+		t.Fatalf("add /a/:name after remove: %v", err)
+	}
+}
+
+func TestOff(t *testing.T) {
+	s := NewServer("test", Option{})
+	ids := make(chan string, 1)
+	s.On("/vehicle/list", protocol.RpcMethodGet, func(req, res *protocol.Message) {})
+	s.On("/vehicle/:id/speed", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		ids <- s.Params(req).ByName("id")
+	})
+	datagrams := make(chan struct{}, 1)
+	s.OnDatagram("/dg", func(req, res *protocol.Message) {
+		datagrams <- struct{}{}
+	})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	call := func(URL string) error {
+		_, err := c.Call(URL, protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage())
+		return err
+	}
+	invalidURL := protocol.StatusText(protocol.StatusInvalidUrl)
+
+	if err := s.Off("/vehicle/list", protocol.RpcMethodGet); err != nil {
+		t.Fatalf("off: %v", err)
+	}
+	if err := call("/vehicle/list"); err == nil || err.Error() != invalidURL {
+		t.Fatalf("call removed route: got %v, want %s", err, invalidURL)
+	}
+	// the parameter sibling is kept
+	if err := call("/vehicle/7/speed"); err != nil {
+		t.Fatalf("call parameter route: %v", err)
+	}
+	if id := <-ids; id != "7" {
+		t.Fatalf("parameter route got id %q, want 7", id)
+	}
+	if err := s.Off("/vehicle/list", protocol.RpcMethodGet); err != ErrNotRegistered {
+		t.Fatalf("off twice: got %v, want ErrNotRegistered", err)
+	}
+
+	if err := s.Off("/vehicle/:id/speed", protocol.RpcMethodGet); err != nil {
+		t.Fatalf("off parameter route: %v", err)
+	}
+	if err := call("/vehicle/7/speed"); err == nil || err.Error() != invalidURL {
+		t.Fatalf("call removed parameter route: got %v, want %s", err, invalidURL)
+	}
+
+	if err := s.OffDatagram("/dg"); err != nil {
+		t.Fatalf("off datagram: %v", err)
+	}
+	if err := s.OffDatagram("/dg"); err != ErrNotRegistered {
+		t.Fatalf("off datagram twice: got %v, want ErrNotRegistered", err)
+	}
+	if _, err := c.Call("/dg", protocol.TypeDatagram, protocol.ChannelNormal, protocol.NewMessage()); err != nil {
+		t.Fatalf("datagram: %v", err)
+	}
+	select {
+	case <-datagrams:
+		t.Fatal("removed datagram handler was called")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	s := NewServer("test", Option{})
+
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(req, res *protocol.Message) {
+				calls = append(calls, name)
+				next(req, res)
+				calls = append(calls, name+" done")
+			}
+		}
+	}
+	deny := func(next Handler) Handler {
+		return func(req, res *protocol.Message) {
+			calls = append(calls, "deny")
+			res.SetStatusType(protocol.StatusNoPermissions)
+		}
+	}
+	handler := func(req, res *protocol.Message) {
+		calls = append(calls, "handler")
+	}
+
+	s.Use(trace("global"))
+	api := s.Group("/api", trace("api"))
+	api.Group("v1", trace("v1")).On("/x", protocol.RpcMethodGet, handler)
+	api.Group("admin", deny, trace("admin")).On("/x", protocol.RpcMethodGet, handler)
+	// middleware added after the routes still apply
+	api.Use(trace("api late"))
+
+	tests := []struct {
+		url    string
+		status protocol.StatusType
+		calls  []string
+	}{
+		{"/api/v1/x", protocol.StatusSuccess, []string{
+			"global", "api", "api late", "v1", "handler", "v1 done", "api late done", "api done", "global done"}},
+		{"/api/admin/x", protocol.StatusNoPermissions, []string{
+			"global", "api", "api late", "deny", "api late done", "api done", "global done"}},
+	}
+	for _, tt := range tests {
+		calls = nil
+		entry, params := s.router.lookupRPC(protocol.RpcMethodGet, tt.url)
+		if entry == nil {
+			t.Fatalf("lookup %q: no route", tt.url)
+		}
+		req, res := protocol.NewMessage(), protocol.NewMessage()
+		s.callHandler(entry, params, 0, req, res)
+
+		if got := res.StatusType(); got != tt.status {
+			t.Errorf("%s: status %s, want %s", tt.url, protocol.StatusText(got), protocol.StatusText(tt.status))
+		}
+		if !reflect.DeepEqual(calls, tt.calls) {
+			t.Errorf("%s: calls %v, want %v", tt.url, calls, tt.calls)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"io"
@@ -27,6 +29,9 @@ var (
 	ErrWrongPublishTriger   = errors.New("wrong publish triger")
 	ErrNotRawPublishURL     = errors.New("not raw publish URL, does not need triger")
 	ErrAlreadyRegistered    = errors.New("URL has been Registered")
+	ErrNotRegistered        = errors.New("URL is not Registered")
+	ErrClientNotFound       = errors.New("client not found or not active")
+	ErrNoQuickChannel       = errors.New("client has no quick channel")
 )
 
 const (
@@ -36,6 +41,9 @@ const (
 	WriterBuffsize = 1024
 
 	DefaultTimeout = 5 * time.Minute
+
+	// shutdownPollInterval is how often Shutdown checks in-flight handlers.
+	shutdownPollInterval = 10 * time.Millisecond
 )
 
 // VsoaServer is interface that defines one client to call one server.
@@ -85,6 +93,25 @@ type client struct {
 	Authed         bool
 	Active         bool
 	Subscribes     map[string]bool // key: URL, value: If Subs
+	// QoS settings requested by the client with TypeQosSetup
+	Qos        protocol.QosSetupParam
+	pubLimiter *pubLimiter
+	// ctx is cancelled when the connection is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	values sync.Map // per client key/value storage of handlers
+	// outbound frames, written by the writer goroutine of the client
+	queue *writeQueue
+	// identity given by Option.Authenticator, nil without authentication
+	identity *Identity
+	// seals the quick channel, nil if it is plaintext
+	quickCipher *protocol.QuickCipher
+	// quick packets must be signed with it if Option.QuickAuth is set
+	quickToken []byte
+	// verifies quick packets signed with quickToken
+	quickSigner *protocol.QuickSigner
+	// Param codec of typed handlers, negotiated in ServInfo
+	codec protocol.Codec
 }
 
 // Handler declares the signature of a function that can be bound to a Route.
@@ -92,6 +119,7 @@ type Handler func(req *protocol.Message, resp *protocol.Message)
 type serverHandler struct {
 	handler Handler
 	rawFlag bool
+	group   *Group // route group of the handler, nil for server routes
 }
 
 // Server is the VSOA server that use TCP with UDP.
@@ -104,9 +132,12 @@ type Server struct {
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 
-	routerMapMu sync.RWMutex
-	routeMap    map[string]serverHandler
-	triggerChan map[string]chan struct{}
+	router      router
+	middleware  []Middleware             // protected by router.mu
+	triggerChan map[string]chan struct{} // protected by router.mu
+	pubStops    map[string]chan struct{} // protected by router.mu
+	// request context of in-flight requests, key: *protocol.Message
+	requests sync.Map
 
 	mu      sync.RWMutex
 	clients map[uint32]*client
@@ -114,6 +145,11 @@ type Server struct {
 	quickChannel map[string]uint32
 	clientsCount atomic.Uint32
 	doneChan     chan struct{}
+	// closed by Shutdown to stop all publishers
+	pubStop     chan struct{}
+	pubStopOnce sync.Once
+	// frames dropped by client write queues
+	droppedFrames atomic.Uint64
 
 	isStarted  atomic.Bool
 	isShutdown atomic.Bool
@@ -133,6 +169,11 @@ type Server struct {
 	// the return value `authed` is to make pubs to or not to goto the client.
 	HandleOnClient func(clientUid uint32) (authed bool, err error)
 
+	// HandleQosSetup is used to accept or reject the client QoS setup request.
+	// It may change the settings in qos, return protocol.StatusSuccess to accept them.
+	// If not set, all settings inside VSOA limits are accepted.
+	HandleQosSetup func(clientUid uint32, qos *protocol.QosSetupParam) protocol.StatusType
+
 	// ServerErrorFunc is a customized error handlers and you can use it to return customized error strings to clients.
 	// If not set, it use err.Error()
 	ServerErrorFunc func(res *protocol.Message, err error) string
@@ -152,8 +193,10 @@ func NewServer(name string, so Option) *Server {
 		quickChannel: make(map[string]uint32),
 		clients:      make(map[uint32]*client),
 		doneChan:     make(chan struct{}),
-		routeMap:     make(map[string]serverHandler),
+		tlsConfig:    newTLSConfig(&so),
+		pubStop:      make(chan struct{}),
 		triggerChan:  make(map[string]chan struct{}),
+		pubStops:     make(map[string]chan struct{}),
 	}
 
 	s.isStarted.Store(false)
@@ -177,6 +220,7 @@ func (s *Server) Serve(address string) (err error) {
 
 	s.address = address
 	s.mu.Lock()
+	s.ln = ln
 	s.doneChan = make(chan struct{})
 	s.mu.Unlock()
 	s.isStarted.Store(true)
@@ -200,7 +244,14 @@ func (s *Server) Close() (err error) {
 	s.isShutdown.Store(true)
 	s.isStarted.Store(false)
 
+	s.mu.RLock()
+	uids := make([]uint32, 0, len(s.clients))
 	for cuid := range s.clients {
+		uids = append(uids, cuid)
+	}
+	s.mu.RUnlock()
+
+	for _, cuid := range uids {
 		s.closeConn(cuid)
 	}
 
@@ -224,6 +275,104 @@ func (s *Server) Close() (err error) {
 	return nil
 }
 
+// Shutdown gracefully shuts down the server.
+//
+// It stops accepting new connections and quick channel requests, stops all
+// publishers and waits for in-flight handlers to finish. Then every client
+// gets the queued responses, and a shutdown notice if Option.ShutdownNotice
+// is set, before its connection is closed.
+// If ctx expires first, the remaining connections are closed at once and
+// ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) (err error) {
+	if !s.isStarted.Load() || s.IsShutdown() {
+		return nil
+	}
+
+	s.isShutdown.Store(true)
+	s.isStarted.Store(false)
+
+	s.mu.Lock()
+	ln := s.ln
+	qln := s.qln
+	s.mu.Unlock()
+
+	if ln != nil {
+		_ = ln.Close()
+	}
+	if qln != nil {
+		_ = qln.Close()
+	}
+	s.pubStopOnce.Do(func() { close(s.pubStop) })
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for err == nil && atomic.LoadInt32(&s.handlerMsgNum) > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	notice := protocol.NewMessage()
+	protocol.NewShutdownNotice(notice)
+	frame, _ := notice.Encode(protocol.ChannelNormal)
+
+	// Active is written under s.mu, copy it with the clients
+	type closing struct {
+		*client
+		active bool
+	}
+	s.mu.RLock()
+	clients := make([]closing, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, closing{c, c.Active})
+	}
+	s.mu.RUnlock()
+
+	for _, c := range clients {
+		if c.queue == nil {
+			continue
+		}
+		if err == nil && c.active && s.option.ShutdownNotice {
+			s.enqueue(c.client, frame, false)
+		}
+		c.queue.drain()
+	}
+	for _, c := range clients {
+		if err == nil && c.queue != nil {
+			select {
+			case <-c.queue.done:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		s.closeConn(c.Uid)
+	}
+
+	s.mu.Lock()
+	doneChan := s.doneChan
+	s.doneChan = nil
+	s.mu.Unlock()
+
+	if doneChan != nil {
+		close(doneChan)
+	}
+
+	return err
+}
+
+// waitDone waits until Close or Shutdown is done.
+func (s *Server) waitDone() {
+	s.mu.RLock()
+	doneChan := s.doneChan
+	s.mu.RUnlock()
+
+	if doneChan != nil {
+		<-doneChan
+	}
+}
+
 func (s *Server) Count() (count int) {
 	if !s.isStarted.Load() || s.IsShutdown() {
 		return 0
@@ -273,9 +422,7 @@ func (s *Server) serveListener(ln net.Listener) error {
 		conn, e := s.ln.Accept()
 		if e != nil {
 			if s.IsShutdown() {
-				if s.doneChan != nil {
-					<-s.doneChan
-				}
+				s.waitDone()
 				return ErrServerClosed
 			}
 			if errors.Is(e, net.ErrClosed) || strings.Contains(e.Error(), "use of closed network connection") {
@@ -302,9 +449,11 @@ func (s *Server) serveListener(ln net.Listener) error {
 
 		CUid := s.clientsCount.Add(1)
 
+		ctx, cancel := context.WithCancel(context.Background())
+
 		s.mu.Lock()
 		// We don't know the QuickChannel info yet.
-		s.clients[CUid] = &client{
+		c := &client{
 			Conn: conn,
 			Uid:  CUid,
 			// until we got ServInfo
@@ -312,9 +461,14 @@ func (s *Server) serveListener(ln net.Listener) error {
 			beforeServInfo: true,
 			Active:         false,
 			Authed:         false,
+			ctx:            ctx,
+			cancel:         cancel,
+			queue:          newWriteQueue(s.option.WriteQueueSize, s.option.WriteQueuePolicy),
 		}
+		s.clients[CUid] = c
 		s.mu.Unlock()
 
+		go s.serveWriter(c)
 		go s.serveConn(conn, CUid)
 	}
 }
@@ -322,11 +476,11 @@ func (s *Server) serveListener(ln net.Listener) error {
 // sendResponse sends a response to the client.
 //
 // It takes in a res *protocol.Message object, representing the response to be sent,
-// and a conn net.Conn object, representing the network connection to the client.
-// The function encodes the response message and writes it to the connection.
-// If a write timeout is set, it sets the write deadline before writing the response.
+// and the UID of the client to send to.
+// The function encodes the response message and queues it to the client write queue,
+// responses are never dropped by the queue overflow policy.
 // The function returns no values.
-func (s *Server) sendResponse(res *protocol.Message, conn net.Conn) {
+func (s *Server) sendResponse(res *protocol.Message, ClientUid uint32) {
 	// Do service method
 	tmp, err := res.Encode(protocol.ChannelNormal)
 	if err != nil {
@@ -334,11 +488,7 @@ func (s *Server) sendResponse(res *protocol.Message, conn net.Conn) {
 		return
 	}
 
-	if s.writeTimeout != 0 {
-		conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
-	}
-	conn.Write(tmp)
-	protocol.PutData(&tmp)
+	s.enqueueTo(ClientUid, tmp, false)
 }
 
 // serveConn serves a connection and handles incoming requests for the VsoaServer.
@@ -367,11 +517,12 @@ func (s *Server) serveConn(conn net.Conn, ClientUid uint32) {
 			log.Printf("serving %s panic error: %s, stack:\n %s", conn.RemoteAddr(), err, buf)
 		}
 
+		// release the client and cancel its handlers context
+		s.closeConn(ClientUid)
+
 		// make sure all inflight requests are handled and all drained
 		if s.IsShutdown() {
-			if s.doneChan != nil {
-				<-s.doneChan
-			}
+			s.waitDone()
 		}
 	}()
 
@@ -391,10 +542,6 @@ func (s *Server) serveConn(conn net.Conn, ClientUid uint32) {
 
 	// read requests and handle it
 	for {
-		if s.IsShutdown() {
-			return
-		}
-
 		t0 := time.Now()
 		// If client send nothing during readTimeout to server, server will kill the connection!
 		if s.readTimeout != 0 {
@@ -418,8 +565,18 @@ func (s *Server) serveConn(conn net.Conn, ClientUid uint32) {
 			return
 		}
 
+		// counted before the shutdown check, so Shutdown either sees the
+		// request in flight or the request sees the shutdown
+		atomic.AddInt32(&s.handlerMsgNum, 1)
+		if s.IsShutdown() {
+			// Shutdown is draining in-flight requests, drop new ones
+			atomic.AddInt32(&s.handlerMsgNum, -1)
+			continue
+		}
+
 		if !req.IsServInfo() {
 			if !s.clients[ClientUid].Active {
+				atomic.AddInt32(&s.handlerMsgNum, -1)
 				// Close unauthed client
 				s.closeConn(ClientUid)
 				log.Printf("auth failed for conn %s: %v", conn.RemoteAddr().String(), protocol.StatusText(protocol.StatusPassword))
@@ -427,7 +584,7 @@ func (s *Server) serveConn(conn net.Conn, ClientUid uint32) {
 			}
 		}
 
-		go s.processOneRequest(req, conn, ClientUid)
+		go s.processOneRequest(req, ClientUid)
 	}
 }
 
@@ -435,11 +592,10 @@ func (s *Server) serveConn(conn net.Conn, ClientUid uint32) {
 //
 // It takes the following parameters:
 // - req: a pointer to a protocol.Message struct, representing the request message
-// - conn: a net.Conn object, representing the network connection
 // - ClientUid: an unsigned 32-bit integer, representing the client UID
 //
 // There is no return value.
-func (s *Server) processOneRequest(req *protocol.Message, conn net.Conn, ClientUid uint32) {
+func (s *Server) processOneRequest(req *protocol.Message, ClientUid uint32) {
 	defer func() {
 		if r := recover(); r != nil {
 			buf := make([]byte, 1024)
@@ -449,7 +605,6 @@ func (s *Server) processOneRequest(req *protocol.Message, conn net.Conn, ClientU
 		}
 	}()
 
-	atomic.AddInt32(&s.handlerMsgNum, 1)
 	defer atomic.AddInt32(&s.handlerMsgNum, -1)
 
 	res := req.CloneHeader()
@@ -459,7 +614,7 @@ func (s *Server) processOneRequest(req *protocol.Message, conn net.Conn, ClientU
 		if err != nil {
 			log.Printf("Failed to Auth Client: %d, err: %s", ClientUid, err)
 		}
-		s.sendResponse(res, conn)
+		s.sendResponse(res, ClientUid)
 		return
 	}
 
@@ -471,118 +626,95 @@ func (s *Server) processOneRequest(req *protocol.Message, conn net.Conn, ClientU
 	}
 
 	if req.IsPingEcho() {
-		s.sendResponse(res, conn)
+		s.sendResponse(res, ClientUid)
+		return
+	}
+
+	if req.IsQosSetup() {
+		s.qosSetupHandler(req, res, ClientUid)
+		s.sendResponse(res, ClientUid)
 		return
 	}
 
 	if !req.IsOneway() {
 		if req.IsRPC() {
-			if sh, ok := s.routeMap["RPC."+req.MessageRpcMethodText()+
-				"."+string(req.URL)]; ok {
-				if sh.handler != nil {
-					sh.handler(req, res)
-				}
-				res.SetStatusType(protocol.StatusSuccess)
-				goto SEND
+			op := OpGet
+			if req.MessageRpcMethod() == protocol.RpcMethodSet {
+				op = OpSet
 			}
-			if sh, ok := s.routeMap["RPC."+req.MessageRpcMethodText()+
-				"."+string(req.URL)+"/"]; ok {
-				if sh.handler != nil {
-					sh.handler(req, res)
-				}
-				res.SetStatusType(protocol.StatusSuccess)
+			if !s.authorize(ClientUid, op, string(req.URL)) {
+				res.SetStatusType(protocol.StatusNoPermissions)
 				goto SEND
 			}
-			// wdie check if any matches
-			for route, sh := range s.routeMap {
-				// Find one handler and send
-				if strings.HasSuffix(route, "/") &&
-					strings.HasPrefix("RPC."+
-						req.MessageRpcMethodText()+
-						"."+string(req.URL), route) {
-					if sh.handler != nil {
-						sh.handler(req, res)
-					}
-					res.SetStatusType(protocol.StatusSuccess)
-					goto SEND
-				}
+			entry, params := s.router.lookupRPC(req.MessageRpcMethod(), string(req.URL))
+			if entry == nil {
+				res.SetStatusType(protocol.StatusInvalidUrl)
+				goto SEND
 			}
-			res.SetStatusType(protocol.StatusInvalidUrl)
+			// Handler and middleware may change the status
+			res.SetStatusType(protocol.StatusSuccess)
+			s.callHandler(entry, params, ClientUid, req, res)
 			goto SEND
 		} else if req.IsSubscribe() || req.IsUnSubscribe() {
-			url := string(req.URL)
-			if url == "" || url == "/" {
-				s.subs(req, ClientUid)
-				res.SetStatusType(protocol.StatusSuccess)
-				goto SEND
-			}
-			if _, ok := s.routeMap["SUBS/UNSUBS."+string(req.URL)]; ok &&
-				!strings.HasSuffix(string(req.URL), "/") {
-				s.subs(req, ClientUid)
-				res.SetStatusType(protocol.StatusSuccess)
-				goto SEND
-			}
-			if _, ok := s.routeMap["SUBS/UNSUBS."+string(req.URL)+"/"]; ok &&
-				!strings.HasSuffix(string(req.URL), "/") {
-				s.subsF(req, ClientUid)
-				res.SetStatusType(protocol.StatusSuccess)
-				goto SEND
-			}
-			if _, ok := s.routeMap["SUBS/UNSUBS."+string(req.URL)]; ok &&
-				strings.HasSuffix(string(req.URL), "/") {
-				for route := range s.routeMap {
-					if strings.HasPrefix(route, "SUBS/UNSUBS."+string(req.URL)) {
-						s.subsURL(req, route[12:], ClientUid)
-					}
-				}
-				res.SetStatusType(protocol.StatusSuccess)
-				goto SEND
-			}
-			if _, ok := s.routeMap["SUBS/UNSUBS."+string(req.URL[:len(req.URL)-1])]; ok &&
-				strings.HasSuffix(string(req.URL), "/") {
-				s.subsS(req, ClientUid)
+			if req.IsSubscribe() && !s.authorize(ClientUid, OpSubscribe, string(req.URL)) {
+				res.SetStatusType(protocol.StatusNoPermissions)
+			} else if s.subscribeHandler(req, ClientUid) {
 				res.SetStatusType(protocol.StatusSuccess)
-				goto SEND
+			} else {
+				res.SetStatusType(protocol.StatusInvalidUrl)
 			}
-			res.SetStatusType(protocol.StatusInvalidUrl)
 			goto SEND
 		} else {
 			res.SetStatusType(protocol.StatusInvalidUrl)
 		}
 
 	SEND:
-		s.sendResponse(res, conn)
+		s.sendResponse(res, ClientUid)
 	} else {
-		if sh, ok := s.routeMap["DATAGRAME."+string(req.URL)]; ok {
-			if sh.handler != nil {
-				sh.handler(req, res)
-			}
-			return
-		}
-		if sh, ok := s.routeMap["DATAGRAME."+req.MessageRpcMethodText()+
-			"."+string(req.URL)+"/"]; ok {
-			if sh.handler != nil {
-				sh.handler(req, res)
-			}
+		if !s.authorize(ClientUid, OpDatagram, string(req.URL)) {
+			// DATAGRAM has no reply, drop it
 			return
 		}
-		// wdie check if any matches
-		for route, sh := range s.routeMap {
-			// Find one and return
-			if strings.HasSuffix(route, "/") && strings.HasPrefix("DATAGRAME."+string(req.URL), route) {
-				if sh.handler != nil {
-					sh.handler(req, res)
-				}
-				return
-			}
-		}
 		// We still have a Default here
-		if sh, ok := s.routeMap["DATAGRAME.DEFAULT"]; ok {
-			if sh.handler != nil {
-				sh.handler(req, res)
-			}
+		entry, params := s.router.lookupDatagram(string(req.URL))
+		s.callHandler(entry, params, ClientUid, req, res)
+	}
+}
+
+// subscribeHandler updates client subscribes with the publish routes matched URL.
+// It returns false if no publish route matched.
+func (s *Server) subscribeHandler(req *protocol.Message, ClientUid uint32) bool {
+	url := string(req.URL)
+	if url == "" || url == "/" {
+		s.subs(req, ClientUid)
+		return true
+	}
+
+	s.router.mu.RLock()
+	defer s.router.mu.RUnlock()
+
+	if !strings.HasSuffix(url, "/") {
+		if s.router.subs.get(url) != nil {
+			s.subs(req, ClientUid)
+			return true
+		}
+		if s.router.subs.get(url+"/") != nil {
+			s.subsF(req, ClientUid)
+			return true
 		}
+		return false
+	}
+
+	if s.router.subs.get(url[:len(url)-1]) != nil && s.router.subs.get(url) == nil {
+		s.subsS(req, ClientUid)
+		return true
 	}
+	// Subscribe all publish routes under URL
+	entries := s.router.subs.under(url)
+	for _, entry := range entries {
+		s.subsURL(req, entry.pattern, ClientUid)
+	}
+	return len(entries) != 0
 }
 
 // servInfoHandler handles the server information request from a client.
@@ -595,48 +727,61 @@ func (s *Server) servInfoHandler(req *protocol.Message, resp *protocol.Message,
 	s.mu.Lock()
 	s.clients[ClientUid].beforeServInfo = false
 	s.mu.Unlock()
-	if s.option.Password == "" {
-		s.mu.Lock()
-		s.clients[ClientUid].Active = true
-		// quick channel register
-		if req.TunID() != 0 {
-			qAddr := (*net.UDPAddr)(s.clients[ClientUid].Conn.RemoteAddr().(*net.TCPAddr))
-			qAddr.Port = int(req.TunID())
-			qString := qAddr.String()
-			s.clients[ClientUid].QAddr = (qAddr)
-			s.quickChannel[(qString)] = ClientUid
-		}
-		s.clients[ClientUid].Subscribes = make(map[string]bool)
-		s.mu.Unlock()
-		r.NewGoodMessage(protocol.ServInfoResAsString, resp, ClientUid)
-	} else {
-		infoParam := new(protocol.ServInfoReqParam)
-		err := json.Unmarshal(req.Param, infoParam)
-		if err != nil {
+	identity, err := s.authenticate(req, ClientUid)
+	if err != nil {
+		r.NewErrMessage(resp)
+		// After this call server to close normal channel conn
+		return err
+	}
+
+	param := new(protocol.ServInfoReqParam)
+	if len(req.Param) > 0 {
+		// bad params were refused by authenticate if they matter
+		_ = json.Unmarshal(req.Param, param)
+	}
+
+	codec := protocol.SelectCodec(param.Codecs)
+	quickCipher, quickAllowed := s.newQuickCipher(param, ClientUid)
+	var quickToken []byte
+	if s.option.QuickAuth && quickCipher == nil {
+		// sealed quick channel is authenticated already
+		if quickToken, err = protocol.NewQuickToken(); err != nil {
+			r.NewErrMessage(resp)
 			return err
-		} else {
-			if infoParam.Password != s.option.Password {
-				r.NewErrMessage(resp)
-				// After this call server to close normal channel conn
-				return protocol.ErrMessagePasswd
-			} else {
-				s.mu.Lock()
-				s.clients[ClientUid].Active = true
-				// quick channel register
-				if req.TunID() != 0 {
-					qAddr := (*net.UDPAddr)(s.clients[ClientUid].Conn.RemoteAddr().(*net.TCPAddr))
-					qAddr.Port = int(req.TunID())
-					qString := qAddr.String()
-					s.clients[ClientUid].QAddr = (qAddr)
-					s.quickChannel[(qString)] = ClientUid
-				}
-				s.clients[ClientUid].Subscribes = make(map[string]bool)
-				s.mu.Unlock()
-				r.NewGoodMessage(protocol.ServInfoResAsString, resp, ClientUid)
-			}
 		}
 	}
 
+	s.mu.Lock()
+	s.clients[ClientUid].Active = true
+	s.clients[ClientUid].identity = identity
+	s.clients[ClientUid].quickCipher = quickCipher
+	s.clients[ClientUid].quickToken = quickToken
+	if quickToken != nil {
+		s.clients[ClientUid].quickSigner = protocol.NewQuickSigner(quickToken)
+	}
+	s.clients[ClientUid].codec = codec
+	// quick channel register
+	if req.TunID() != 0 && quickAllowed {
+		qAddr := (*net.UDPAddr)(s.clients[ClientUid].Conn.RemoteAddr().(*net.TCPAddr))
+		qAddr.Port = int(req.TunID())
+		qString := qAddr.String()
+		s.clients[ClientUid].QAddr = (qAddr)
+		s.quickChannel[(qString)] = ClientUid
+	}
+	s.clients[ClientUid].Subscribes = make(map[string]bool)
+	s.mu.Unlock()
+	if codec.Name() != protocol.CodecJSON {
+		r.Codec = codec.Name()
+	}
+	if quickCipher != nil || quickToken != nil || r.Codec != "" {
+		// only JSON reply can tell the client
+		r.QuickAEAD = quickCipher != nil
+		r.QuickToken = quickToken
+		r.NewGoodMessage(protocol.ServInfoResAsJSON, resp, ClientUid)
+	} else {
+		r.NewGoodMessage(protocol.ServInfoResAsString, resp, ClientUid)
+	}
+
 	s.onClient(ClientUid)
 	// TODO: handle other client options like ping echo seting logic
 	return nil
@@ -697,14 +842,9 @@ func (s *Server) On(servicePath string, serviceMethod protocol.RpcMessageType, h
 	if handler == nil {
 		return ErrNilHandler
 	}
-	s.routerMapMu.Lock()
-	defer s.routerMapMu.Unlock()
-	if _, ok := s.routeMap["RPC."+protocol.RpcMethodText(serviceMethod)+"."+servicePath]; !ok {
-		s.routeMap["RPC."+protocol.RpcMethodText(serviceMethod)+"."+servicePath] = serverHandler{handler: handler, rawFlag: false}
-	} else {
-		return ErrAlreadyRegistered
-	}
-	return nil
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
+	return s.router.rpcTree(serviceMethod).add(servicePath, serverHandler{handler: handler, rawFlag: false})
 }
 
 // OnDatagram adds a DATAGRAME handler to the VsoaServer.
@@ -714,12 +854,30 @@ func (s *Server) OnDatagram(servicePath string, handler func(*protocol.Message,
 	if handler == nil {
 		return ErrNilHandler
 	}
-	s.routerMapMu.Lock()
-	defer s.routerMapMu.Unlock()
-	if _, ok := s.routeMap["DATAGRAME."+servicePath]; !ok {
-		s.routeMap["DATAGRAME."+servicePath] = serverHandler{handler: handler, rawFlag: false}
-	} else {
-		return ErrAlreadyRegistered
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
+	return s.router.datagram.add(servicePath, serverHandler{handler: handler, rawFlag: false})
+}
+
+// Off removes the RPC handler registered with servicePath and serviceMethod.
+// The requests already being handled are not affected.
+func (s *Server) Off(servicePath string, serviceMethod protocol.RpcMessageType) (err error) {
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
+
+	if s.router.rpcTree(serviceMethod).remove(servicePath) == nil {
+		return ErrNotRegistered
+	}
+	return nil
+}
+
+// OffDatagram removes the DATAGRAME handler registered with servicePath.
+func (s *Server) OffDatagram(servicePath string) (err error) {
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
+
+	if s.router.datagram.remove(servicePath) == nil {
+		return ErrNotRegistered
 	}
 	return nil
 }
@@ -733,9 +891,9 @@ func (s *Server) OnDatagramDefault(handler func(*protocol.Message, *protocol.Mes
 	if handler == nil {
 		return ErrNilHandler
 	}
-	s.routerMapMu.Lock()
-	defer s.routerMapMu.Unlock()
-	s.routeMap["DATAGRAME.DEFAULT"] = serverHandler{handler: handler, rawFlag: false}
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
+	s.router.datagramDefault = &routeEntry{pattern: "", serverHandler: serverHandler{handler: handler, rawFlag: false}}
 	return nil
 }
 
@@ -749,6 +907,12 @@ func (s *Server) OnDatagramDefault(handler func(*protocol.Message, *protocol.Mes
 //
 // It returns an error.
 func (s *Server) Publish(servicePath string, timeOrTrigger any, pubs func(*protocol.Message, *protocol.Message)) (err error) {
+	return s.PublishTo(servicePath, timeOrTrigger, nil, pubs)
+}
+
+// PublishTo adds a publisher like Publish, but the publishes only go to
+// the subscribed clients selected by filter. A nil filter selects all clients.
+func (s *Server) PublishTo(servicePath string, timeOrTrigger any, filter ClientFilter, pubs func(*protocol.Message, *protocol.Message)) (err error) {
 	if pubs == nil {
 		return ErrNilPublishHandler
 	}
@@ -765,17 +929,14 @@ func (s *Server) Publish(servicePath string, timeOrTrigger any, pubs func(*proto
 	default:
 		return ErrWrongPublishTriger
 	}
-	s.routerMapMu.Lock()
-	defer s.routerMapMu.Unlock()
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
 
-	if _, ok := s.routeMap["SUBS/UNSUBS."+servicePath]; !ok {
-		// No need to have handler save in the routeMap
-		s.routeMap["SUBS/UNSUBS."+servicePath] = serverHandler{handler: pubs, rawFlag: rawFlag}
-		// Maybe it's bad to run a Publisher for each pub
-		go s.publisher(servicePath, timeOrTrigger, pubs)
-	} else {
-		return ErrAlreadyRegistered
+	if err = s.router.subs.add(servicePath, serverHandler{handler: pubs, rawFlag: rawFlag}); err != nil {
+		return err
 	}
+	// Maybe it's bad to run a Publisher for each pub
+	go s.publisher(servicePath, timeOrTrigger, filter, pubs, s.addPublisher(servicePath, timeOrTrigger))
 	return nil
 }
 
@@ -789,9 +950,21 @@ func (s *Server) Publish(servicePath string, timeOrTrigger any, pubs func(*proto
 // Returns:
 // - err: an error if the publisher is already registered, otherwise nil
 func (s *Server) QuickPublish(servicePath string, timeOrTrigger any, pubs func(*protocol.Message, *protocol.Message)) (err error) {
+	return s.QuickPublishTo(servicePath, timeOrTrigger, nil, pubs)
+}
+
+// QuickPublishTo adds a quick channel publisher like QuickPublish, but the
+// publishes only go to the subscribed clients selected by filter.
+// A nil filter selects all clients.
+func (s *Server) QuickPublishTo(servicePath string, timeOrTrigger any, filter ClientFilter, pubs func(*protocol.Message, *protocol.Message)) (err error) {
 	if pubs == nil {
 		return ErrNilPublishHandler
 	}
+
+	if !strings.HasPrefix(servicePath, "/") {
+		servicePath = "/" + servicePath
+	}
+
 	rawFlag := false
 	switch timeOrTrigger.(type) {
 	case time.Duration:
@@ -800,35 +973,86 @@ func (s *Server) QuickPublish(servicePath string, timeOrTrigger any, pubs func(*
 	default:
 		return ErrWrongPublishTriger
 	}
-	s.routerMapMu.Lock()
-	defer s.routerMapMu.Unlock()
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
 
-	if _, ok := s.routeMap["SUBS/UNSUBS."+servicePath]; !ok {
-		// No need to have handler save in the routeMap
-		s.routeMap["SUBS/UNSUBS."+servicePath] = serverHandler{handler: pubs, rawFlag: rawFlag}
-		// Maybe it's bad to run a Publisher for each pub
-		go s.qpublisher(servicePath, timeOrTrigger, pubs)
-	} else {
-		return ErrAlreadyRegistered
+	if err = s.router.subs.add(servicePath, serverHandler{handler: pubs, rawFlag: rawFlag}); err != nil {
+		return err
 	}
+	// Maybe it's bad to run a Publisher for each pub
+	go s.qpublisher(servicePath, timeOrTrigger, filter, pubs, s.addPublisher(servicePath, timeOrTrigger))
 	return nil
 }
 
+// addPublisher saves the trigger and returns the stop channel of the
+// publisher of servicePath. It is called with router.mu held.
+func (s *Server) addPublisher(servicePath string, timeOrTrigger any) chan struct{} {
+	if trigger, ok := timeOrTrigger.(chan struct{}); ok {
+		s.triggerChan[servicePath] = trigger
+	}
+
+	stop := make(chan struct{})
+	s.pubStops[servicePath] = stop
+	return stop
+}
+
+// Unpublish stops the publisher of servicePath and unregisters it.
+// It works for Publish, QuickPublish and RegisterPublishURL routes.
+// Clients subscribed to servicePath, or to a parent URL of it, get an
+// UNSUBSCRIBE message from server.
+func (s *Server) Unpublish(servicePath string) error {
+	if !strings.HasPrefix(servicePath, "/") {
+		servicePath = "/" + servicePath
+	}
+
+	s.router.mu.Lock()
+	entry := s.router.subs.remove(servicePath)
+	if stop, ok := s.pubStops[servicePath]; ok {
+		close(stop)
+		delete(s.pubStops, servicePath)
+	}
+	delete(s.triggerChan, servicePath)
+	s.router.mu.Unlock()
+
+	if entry == nil {
+		return ErrNotRegistered
+	}
+
+	s.notifyUnpublished(servicePath)
+	return nil
+}
+
+// TriggerPublisher makes the raw publisher of servicePath, registered by
+// Publish or QuickPublish with a trigger channel, publish once.
+// It waits until the publisher takes the trigger, and returns an error
+// instead if the publisher is stopped by Unpublish or Shutdown.
 func (s *Server) TriggerPublisher(servicePath string) error {
-	if s.routeMap["SUBS/UNSUBS."+servicePath].handler == nil {
+	entry := s.router.getPublish(servicePath)
+	if entry == nil || entry.handler == nil {
 		return ErrNilPublishHandler
 	}
 
-	if !s.routeMap["SUBS/UNSUBS."+servicePath].rawFlag {
+	if !entry.rawFlag {
 		return ErrNotRawPublishURL
 	}
 
-	if s.triggerChan[servicePath] == nil {
-		s.triggerChan[servicePath] = make(chan struct{}, 100)
+	s.router.mu.Lock()
+	trigger := s.triggerChan[servicePath]
+	stop := s.pubStops[servicePath]
+	s.router.mu.Unlock()
+	if trigger == nil {
+		return ErrNotRegistered
 	}
 
-	s.triggerChan[servicePath] <- struct{}{}
-	return nil
+	// the publisher may be stopped by Unpublish or Shutdown meanwhile
+	select {
+	case trigger <- struct{}{}:
+		return nil
+	case <-stop:
+		return ErrNotRegistered
+	case <-s.pubStop:
+		return ErrServerClosed
+	}
 }
 
 // subs updates the subscription status of a client.
@@ -910,6 +1134,12 @@ func (s *Server) closeConn(ClientUid uint32) {
 	if c.Conn != nil {
 		c.Conn.Close()
 	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.queue != nil {
+		c.queue.close()
+	}
 
 	// Clear Client Subscribes map
 	for k := range c.Subscribes {
@@ -926,8 +1156,39 @@ func (s *Server) closeConn(ClientUid uint32) {
 // Option contains all options for creating server.
 type Option struct {
 	Password string
+	// Authenticator authenticates clients in the ServInfo handshake,
+	// it replaces the Password check if it is set.
+	Authenticator Authenticator
+	// ACL authorizes RPC, subscribe and DATAGRAM operations of clients by
+	// their identities. Denied RPC and subscribe get protocol.StatusNoPermissions,
+	// denied DATAGRAM is dropped.
+	ACL *ACL
 	// TLSConfig for tcp and quic
 	TLSConfig *tls.Config
+	// GetCertificate returns the server certificate of every TLS handshake,
+	// e.g. CertReloader.GetCertificate. It enables TLS without TLSConfig.
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// ClientCAs enables mutual TLS, clients must have a certificate signed by them.
+	ClientCAs *x509.CertPool
+	// QuickEncryption requires TLS clients to seal the quick channel,
+	// TLS clients not asking for it get no quick channel.
+	// Without it, the quick channel is still sealed if the client asks.
+	QuickEncryption bool
+	// QuickAuth gives every client a session token in the ServInfo reply,
+	// quick packets not signed with it, replayed or not having the client
+	// UID as SeqNo are dropped. Sealed quick channels do not need it.
+	QuickAuth bool
 	// automatic auth all clients to get pubs
 	AutoAuth bool
+	// WriteQueueSize is the max number of frames queued to one client,
+	// DefaultWriteQueueSize is used if it is 0.
+	WriteQueueSize int
+	// WriteQueuePolicy decides what to do with a publish or push frame
+	// when the client write queue is full, DropOldest by default.
+	WriteQueuePolicy OverflowPolicy
+	// ShutdownNotice makes Shutdown send every client a shutdown notice
+	// (see protocol.NewShutdownNotice) before closing its connection.
+	// The notice is a go-vsoa protocol extension, other VSOA clients may
+	// not know it, so it is off by default.
+	ShutdownNotice bool
 }
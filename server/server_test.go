@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// startTestServer serves s on a free local port and returns its address
+// and the error returned by Serve.
+func startTestServer(t *testing.T, s *Server) (string, <-chan error) {
+	t.Helper()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Serve("127.0.0.1:0")
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.RLock()
+		ln := s.ln
+		s.mu.RUnlock()
+		if ln != nil {
+			return ln.Addr().String(), errCh
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitQuickListener waits for the quick channel listener of s.
+func waitQuickListener(t *testing.T, s *Server) {
+	t.Helper()
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s.mu.RLock()
+		qln := s.qln
+		s.mu.RUnlock()
+		if qln != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("quick listener did not start in time")
+		}
+	}
+}
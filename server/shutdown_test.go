@@ -1,10 +1,15 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net"
 	"testing"
 	"time"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
 )
 
 func TestServeQuickListenerReturnsErrServerClosedOnClosedSocket(t *testing.T) {
@@ -23,19 +28,13 @@ func TestServeQuickListenerReturnsErrServerClosedOnClosedSocket(t *testing.T) {
 		errCh <- s.serveQuickListener("")
 	}()
 
-	deadline := time.Now().Add(2 * time.Second)
-	for {
-		if s.qln != nil {
-			break
-		}
-		if time.Now().After(deadline) {
-			t.Fatal("quick listener did not start in time")
-		}
-		time.Sleep(10 * time.Millisecond)
-	}
+	waitQuickListener(t, s)
 
 	s.isShutdown.Store(true)
-	if err := s.qln.Close(); err != nil {
+	s.mu.RLock()
+	qln := s.qln
+	s.mu.RUnlock()
+	if err := qln.Close(); err != nil {
 		t.Fatalf("close udp listener: %v", err)
 	}
 
@@ -80,3 +79,131 @@ func TestCloseClosesDoneChan(t *testing.T) {
 		t.Fatal("doneChan was not closed by Close")
 	}
 }
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	s := NewServer("test", Option{ShutdownNotice: true})
+	s.On("/slow", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		time.Sleep(200 * time.Millisecond)
+		res.Param = json.RawMessage(`"done"`)
+	})
+	addr, errCh := startTestServer(t, s)
+
+	notices := make(chan *protocol.Message, 10)
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	c.ServerMessageChan = notices
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	type result struct {
+		reply *protocol.Message
+		err   error
+	}
+	callCh := make(chan result, 1)
+	go func() {
+		reply, err := c.Call("/slow", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage())
+		callCh <- result{reply, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	select {
+	case r := <-callCh:
+		if r.err != nil {
+			t.Fatalf("in-flight call failed: %v", r.err)
+		}
+		if string(r.reply.Param) != `"done"` {
+			t.Fatalf("in-flight call reply %s, want \"done\"", r.reply.Param)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("in-flight call did not finish")
+	}
+
+	select {
+	case m := <-notices:
+		if !protocol.IsShutdownNotice(m) {
+			t.Fatalf("got %s message, want shutdown notice", m.MessageTypeText())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not get shutdown notice")
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Fatalf("expected ErrServerClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+}
+
+func TestShutdownWithoutNotice(t *testing.T) {
+	s := NewServer("test", Option{})
+	addr, _ := startTestServer(t, s)
+
+	messages := make(chan *protocol.Message, 10)
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	c.ServerMessageChan = messages
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	// the client still tells ServerMessageChan that the connection is closed
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case m := <-messages:
+			if protocol.IsShutdownNotice(m) {
+				t.Fatal("got shutdown notice without Option.ShutdownNotice")
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestShutdownContextExpired(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	s := NewServer("test", Option{})
+	s.On("/block", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		<-release
+	})
+	addr, _ := startTestServer(t, s)
+
+	c := vsoaclient.NewClient(vsoaclient.Option{})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	go c.Call("/block", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage())
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	s.mu.RLock()
+	n := len(s.clients)
+	s.mu.RUnlock()
+	if n != 0 {
+		t.Fatalf("%d clients still connected after Shutdown", n)
+	}
+}
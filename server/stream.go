@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -17,6 +18,7 @@ type ServerStream struct {
 }
 
 // NewServerStream creates a new Stream using tunid in res.
+// The stream uses TLS if the server uses TLS.
 //
 // It takes a pointer to a Server object, s, and a pointer to a protocol.Message object, res, as parameters.
 // It returns a pointer to a ServerStream object, ss, and an error object, err.
@@ -40,6 +42,9 @@ func (s *Server) NewServerStream(res *protocol.Message) (ss *ServerStream, err e
 	}
 
 	tunid := uint16(ln.Addr().(*net.TCPAddr).Port)
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
 
 	res.SetTunId(tunid)
 	res.SetValidTunid()
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval limits how often CertReloader checks the files.
+const certCheckInterval = time.Second
+
+// newTLSConfig returns the server TLS config made from so, nil if so has no TLS settings.
+func newTLSConfig(so *Option) *tls.Config {
+	if so.TLSConfig == nil && so.GetCertificate == nil {
+		return nil
+	}
+
+	config := new(tls.Config)
+	if so.TLSConfig != nil {
+		config = so.TLSConfig.Clone()
+	}
+	if so.GetCertificate != nil {
+		config.GetCertificate = so.GetCertificate
+	}
+	if so.ClientCAs != nil {
+		config.ClientCAs = so.ClientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
+
+// CertReloader keeps a certificate loaded from files, and loads it again
+// when the files are changed. Use its GetCertificate as
+// Option.GetCertificate to renew the server certificate without restart.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader loads the certificate from certFile and keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate from the files now.
+// The old certificate is kept if the files are not valid.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.load(r.filesModTime())
+}
+
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// filesModTime returns the latest modification time of the files.
+func (r *CertReloader) filesModTime() time.Time {
+	var t time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(name); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+// GetCertificate returns the current certificate, it is loaded again first
+// if the files are changed.
+func (r *CertReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.lastCheck) >= certCheckInterval {
+		r.lastCheck = now
+		if modTime := r.filesModTime(); !modTime.Equal(r.modTime) {
+			if err := r.load(modTime); err != nil {
+				log.Printf("VSOA: reload certificate %s: %v, keep the old one", r.certFile, err)
+			}
+		}
+	}
+	return r.cert, nil
+}
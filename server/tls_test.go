@@ -0,0 +1,239 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a PEM certificate and key signed by ca.
+func (ca *testCA) issue(t *testing.T, serial int64, subject pkix.Name) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeKeyPair(t *testing.T, dir string, certPEM, keyPEM []byte) (certFile, keyFile string) {
+	t.Helper()
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, 2, pkix.Name{CommonName: "server"})
+	reloader, err := NewCertReloader(writeKeyPair(t, t.TempDir(), certPEM, keyPEM))
+	if err != nil {
+		t.Fatalf("load server certificate: %v", err)
+	}
+
+	s := NewServer("test", Option{
+		GetCertificate: reloader.GetCertificate,
+		ClientCAs:      ca.pool,
+		Authenticator:  &CertAuthenticator{},
+	})
+	s.On("/whoami", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		res.Param, _ = json.Marshal(s.Context(req).Identity.Name)
+	})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	clientCert, err := tls.X509KeyPair(ca.issue(t, 3, pkix.Name{CommonName: "ecu-1"}))
+	if err != nil {
+		t.Fatalf("load client certificate: %v", err)
+	}
+
+	c := vsoaclient.NewClient(vsoaclient.Option{TLSConfig: &tls.Config{RootCAs: ca.pool}})
+	if _, err := c.Connect("vsoa", addr); err == nil {
+		t.Fatal("client without certificate connected")
+	}
+
+	c = vsoaclient.NewClient(vsoaclient.Option{TLSConfig: &tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: []tls.Certificate{clientCert},
+	}})
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	reply, err := c.Call("/whoami", protocol.TypeRPC, protocol.RpcMethodGet, protocol.NewMessage())
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if string(reply.Param) != `"ecu-1"` {
+		t.Fatalf("got identity %s, want \"ecu-1\"", reply.Param)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+
+	certPEM, keyPEM := ca.issue(t, 2, pkix.Name{CommonName: "old"})
+	r, err := NewCertReloader(writeKeyPair(t, dir, certPEM, keyPEM))
+	if err != nil {
+		t.Fatalf("load certificate: %v", err)
+	}
+
+	certPEM, keyPEM = ca.issue(t, 3, pkix.Name{CommonName: "new"})
+	certFile, _ := writeKeyPair(t, dir, certPEM, keyPEM)
+	// make sure the change is seen on file systems with coarse timestamps
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	r.lastCheck = time.Time{}
+
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("get certificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if leaf.Subject.CommonName != "new" {
+		t.Fatalf("got certificate %q, want \"new\"", leaf.Subject.CommonName)
+	}
+}
+
+func TestQuickEncryption(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, err := tls.X509KeyPair(ca.issue(t, 2, pkix.Name{CommonName: "server"}))
+	if err != nil {
+		t.Fatalf("load server certificate: %v", err)
+	}
+
+	s := NewServer("test", Option{
+		TLSConfig:       &tls.Config{Certificates: []tls.Certificate{serverCert}},
+		QuickEncryption: true,
+	})
+	received := make(chan string, 1)
+	s.OnDatagram("/quick", func(req, res *protocol.Message) {
+		received <- string(req.Data)
+	})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+	waitQuickListener(t, s)
+
+	// TLS client without sealed quick channel gets no quick channel
+	plain := vsoaclient.NewClient(vsoaclient.Option{TLSConfig: &tls.Config{RootCAs: ca.pool}})
+	if _, err := plain.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer plain.Close()
+	if err := s.DatagramTo(plain.GetUid(), protocol.NewMessage(), protocol.ChannelQuick); err != ErrNoQuickChannel {
+		t.Fatalf("DatagramTo plaintext client: got %v, want ErrNoQuickChannel", err)
+	}
+
+	c := vsoaclient.NewClient(vsoaclient.Option{
+		TLSConfig:       &tls.Config{RootCAs: ca.pool},
+		QuickEncryption: true,
+	})
+	messages := make(chan *protocol.Message, 1)
+	c.ServerMessageChan = messages
+	if _, err := c.Connect("vsoa", addr); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer c.Close()
+
+	req := protocol.NewMessage()
+	req.Data = []byte("ping")
+	if _, err := c.Call("/quick", protocol.TypeDatagram, protocol.ChannelQuick, req); err != nil {
+		t.Fatalf("quick datagram: %v", err)
+	}
+	select {
+	case data := <-received:
+		if data != "ping" {
+			t.Fatalf("server got %q, want \"ping\"", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not get the sealed datagram")
+	}
+
+	msg := protocol.NewMessage()
+	msg.URL = []byte("/quick")
+	msg.Data = []byte("pong")
+	if err := s.DatagramTo(c.GetUid(), msg, protocol.ChannelQuick); err != nil {
+		t.Fatalf("DatagramTo: %v", err)
+	}
+	select {
+	case m := <-messages:
+		if string(m.Data) != "pong" {
+			t.Fatalf("client got %q, want \"pong\"", m.Data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not get the sealed datagram")
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+// StatusError is returned by typed handlers to reply with a VSOA status.
+type StatusError protocol.StatusType
+
+func (e StatusError) Error() string {
+	return protocol.StatusText(protocol.StatusType(e))
+}
+
+// OnTyped adds an RPC handler with typed request and reply.
+//
+// The request Param is decoded to Req and the returned Resp is encoded to
+// the reply Param, both with the codec negotiated by the client. Param that
+// can not be decoded is replied with StatusArguments. If handler returns a
+// StatusError its status is replied, other errors are failures of the
+// handler, not of the request, and reply StatusNoResponding.
+func OnTyped[Req, Resp any](s *Server, servicePath string, serviceMethod protocol.RpcMessageType,
+	handler func(ctx *Context, req Req) (Resp, error)) error {
+	if handler == nil {
+		return ErrNilHandler
+	}
+
+	return s.On(servicePath, serviceMethod, func(req, res *protocol.Message) {
+		ctx := s.Context(req)
+
+		var in Req
+		if len(req.Param) > 0 {
+			if err := ctx.Codec.Unmarshal(req.Param, &in); err != nil {
+				res.SetStatusType(protocol.StatusArguments)
+				return
+			}
+		}
+
+		out, err := handler(ctx, in)
+		if err != nil {
+			var se StatusError
+			if errors.As(err, &se) {
+				res.SetStatusType(protocol.StatusType(se))
+			} else {
+				res.SetStatusType(protocol.StatusNoResponding)
+			}
+			return
+		}
+
+		param, err := ctx.Codec.Marshal(out)
+		if err != nil {
+			res.SetStatusType(protocol.StatusArguments)
+			return
+		}
+		res.Param = param
+	})
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	vsoaclient "github.com/acoinfo/vsoa/client"
+	"github.com/acoinfo/vsoa/protocol"
+)
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type sumReq struct{ A, B int }
+type sumRes struct {
+	Sum   int
+	Codec string
+}
+
+func TestOnTyped(t *testing.T) {
+	protocol.RegisterCodec(gobCodec{})
+
+	s := NewServer("test", Option{})
+	OnTyped(s, "/sum", protocol.RpcMethodGet, func(ctx *Context, req sumReq) (sumRes, error) {
+		if req.A < 0 {
+			return sumRes{}, StatusError(protocol.StatusNoPermissions)
+		}
+		if req.A > 100 {
+			return sumRes{}, errors.New("overflow")
+		}
+		return sumRes{Sum: req.A + req.B, Codec: ctx.Codec.Name()}, nil
+	})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	for _, codec := range []string{"gob", protocol.CodecJSON} {
+		c := vsoaclient.NewClient(vsoaclient.Option{Codecs: []string{codec}})
+		if _, err := c.Connect("vsoa", addr); err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer c.Close()
+
+		res, err := vsoaclient.CallTyped[sumReq, sumRes](c, "/sum", protocol.RpcMethodGet, sumReq{A: 1, B: 2})
+		if err != nil {
+			t.Fatalf("%s: call: %v", codec, err)
+		}
+		if res.Sum != 3 || res.Codec != codec {
+			t.Fatalf("%s: got %+v", codec, res)
+		}
+
+		want := protocol.StatusText(protocol.StatusNoPermissions)
+		if _, err := vsoaclient.CallTyped[sumReq, sumRes](c, "/sum", protocol.RpcMethodGet, sumReq{A: -1}); err == nil || err.Error() != want {
+			t.Fatalf("%s: got %v, want %q", codec, err, want)
+		}
+
+		want = protocol.StatusText(protocol.StatusNoResponding)
+		if _, err := vsoaclient.CallTyped[sumReq, sumRes](c, "/sum", protocol.RpcMethodGet, sumReq{A: 101}); err == nil || err.Error() != want {
+			t.Fatalf("%s: handler error: got %v, want %q", codec, err, want)
+		}
+	}
+
+	c := vsoaclient.NewClient(vsoaclient.Option{Codecs: []string{"unknown"}})
+	if _, err := c.Connect("vsoa", addr); err != vsoaclient.ErrUnsupportedCodec {
+		t.Fatalf("unknown codec: got %v, want ErrUnsupportedCodec", err)
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	protocol.RegisterCodec(gobCodec{})
+
+	s := NewServer("test", Option{})
+	type negotiated struct {
+		codec string
+		req   sumReq
+		err   error
+	}
+	got := make(chan negotiated, 1)
+	s.On("/raw", protocol.RpcMethodGet, func(req, res *protocol.Message) {
+		// Param is sent as the codec encoded it
+		var in sumReq
+		codec := s.Context(req).Codec
+		err := codec.Unmarshal(req.Param, &in)
+		got <- negotiated{codec.Name(), in, err}
+	})
+	addr, _ := startTestServer(t, s)
+	defer s.Close()
+
+	tests := []struct {
+		offered []string
+		want    string
+	}{
+		{[]string{"gob", protocol.CodecJSON}, "gob"},
+		{[]string{protocol.CodecJSON, "gob"}, protocol.CodecJSON},
+		{nil, protocol.CodecJSON},
+	}
+	for _, tt := range tests {
+		c := vsoaclient.NewClient(vsoaclient.Option{Codecs: tt.offered})
+		if name := c.Codec().Name(); name != protocol.CodecJSON {
+			t.Fatalf("%v: codec before connect %s, want json", tt.offered, name)
+		}
+		if _, err := c.Connect("vsoa", addr); err != nil {
+			t.Fatalf("%v: connect: %v", tt.offered, err)
+		}
+		defer c.Close()
+
+		if name := c.Codec().Name(); name != tt.want {
+			t.Fatalf("%v: client codec %s, want %s", tt.offered, name, tt.want)
+		}
+		param, err := c.Codec().Marshal(sumReq{A: 1, B: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := protocol.NewMessage()
+		req.Param = param
+		if _, err := c.Call("/raw", protocol.TypeRPC, protocol.RpcMethodGet, req); err != nil {
+			t.Fatalf("%v: call: %v", tt.offered, err)
+		}
+		n := <-got
+		if n.codec != tt.want || n.err != nil || n.req != (sumReq{A: 1, B: 2}) {
+			t.Fatalf("%v: server got %+v, want %s codec", tt.offered, n, tt.want)
+		}
+		if tt.want == "gob" {
+			var v any
+			if json.Unmarshal(param, &v) == nil {
+				t.Fatalf("%v: gob Param is valid JSON", tt.offered)
+			}
+		}
+	}
+}
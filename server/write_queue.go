@@ -0,0 +1,235 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrWriteQueueFull = errors.New("client write queue is full")
+)
+
+// OverflowPolicy decides what a full client write queue does with a new frame.
+type OverflowPolicy int
+
+const (
+	// DropOldest drops the oldest queued publish or push frame to make room for the new one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest drops the new frame.
+	DropNewest
+	// Disconnect drops the new frame and closes the client connection.
+	Disconnect
+)
+
+// DefaultWriteQueueSize is the number of frames a client write queue holds
+// if Option.WriteQueueSize is not set.
+const DefaultWriteQueueSize = 1024
+
+type outFrame struct {
+	b []byte
+	// replies are never dropped, a client waits for them
+	droppable bool
+}
+
+// writeQueue is the bounded outbound frame queue of one client.
+// It is drained by the only writer goroutine of the client, so frames
+// never interleave on the connection and a slow client only blocks itself.
+type writeQueue struct {
+	mu     sync.Mutex
+	frames []outFrame
+	size   int
+	policy OverflowPolicy
+	closed bool
+	// draining stops the writer after queued frames are written
+	draining bool
+	ready    chan struct{}
+	// done is closed when the writer exits
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+func newWriteQueue(size int, policy OverflowPolicy) *writeQueue {
+	if size <= 0 {
+		size = DefaultWriteQueueSize
+	}
+	return &writeQueue{
+		size:   size,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// push queues frame. It returns dropped true if a frame (the new one or
+// an old one) is dropped, and an error if the new frame is not queued.
+// Every frame counts in the queue size, a reply that does not fit is not
+// queued and the caller must close the connection.
+func (q *writeQueue) push(b []byte, droppable bool) (dropped bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false, ErrClientNotFound
+	}
+
+	if len(q.frames) >= q.size {
+		i := -1
+		if q.policy == DropOldest {
+			for j := range q.frames {
+				if q.frames[j].droppable {
+					i = j
+					break
+				}
+			}
+		}
+		q.dropped.Add(1)
+		if i < 0 {
+			return true, ErrWriteQueueFull
+		}
+		copy(q.frames[i:], q.frames[i+1:])
+		q.frames[len(q.frames)-1] = outFrame{}
+		q.frames = q.frames[:len(q.frames)-1]
+		dropped = true
+	}
+
+	q.frames = append(q.frames, outFrame{b: b, droppable: droppable})
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return dropped, nil
+}
+
+// take waits for queued frames and appends all of them to bufs.
+// It returns false after the queue is closed, or drained.
+func (q *writeQueue) take(bufs net.Buffers) (net.Buffers, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return bufs, false
+		}
+		if len(q.frames) != 0 {
+			for i := range q.frames {
+				bufs = append(bufs, q.frames[i].b)
+				q.frames[i] = outFrame{}
+			}
+			q.frames = q.frames[:0]
+			q.mu.Unlock()
+			return bufs, true
+		}
+		if q.draining {
+			q.mu.Unlock()
+			return bufs, false
+		}
+		q.mu.Unlock()
+		<-q.ready
+	}
+}
+
+// drain stops the writer after all queued frames are written.
+// Frames can still be queued until the writer exits.
+func (q *writeQueue) drain() {
+	q.mu.Lock()
+	q.draining = true
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// close drops all queued frames and stops the writer.
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.frames = nil
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// serveWriter writes the queued frames of c to its connection until the
+// queue is closed or drained. Frames queued together are written with one writev.
+// The client is closed if a write fails, a partial frame breaks the stream.
+func (s *Server) serveWriter(c *client) {
+	defer close(c.queue.done)
+
+	var bufs net.Buffers
+	for {
+		var ok bool
+		bufs, ok = c.queue.take(bufs[:0])
+		if !ok {
+			return
+		}
+
+		if s.writeTimeout != 0 {
+			c.Conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		}
+		// WriteTo consumes its receiver, keep bufs for reuse
+		batch := bufs
+		if _, err := batch.WriteTo(c.Conn); err != nil {
+			log.Printf("Vsoa client[%d] write error: %s", c.Uid, err)
+			s.closeConn(c.Uid)
+			return
+		}
+		clear(bufs)
+	}
+}
+
+// enqueue queues an encoded frame to client c.
+// Replies are not droppable, if one does not fit the client is closed,
+// it would wait for the lost reply forever.
+func (s *Server) enqueue(c *client, frame []byte, droppable bool) error {
+	if c.queue == nil {
+		return ErrClientNotFound
+	}
+
+	dropped, err := c.queue.push(frame, droppable)
+	if dropped {
+		s.droppedFrames.Add(1)
+	}
+	if err == ErrWriteQueueFull && (!droppable || c.queue.policy == Disconnect) {
+		log.Printf("Vsoa client[%d] write queue is full, disconnect", c.Uid)
+		s.closeConn(c.Uid)
+	}
+	return err
+}
+
+// enqueueTo queues an encoded frame to the client of clientUid.
+func (s *Server) enqueueTo(clientUid uint32, frame []byte, droppable bool) error {
+	s.mu.RLock()
+	c, ok := s.clients[clientUid]
+	s.mu.RUnlock()
+	if !ok {
+		return ErrClientNotFound
+	}
+	return s.enqueue(c, frame, droppable)
+}
+
+// DroppedFrames returns the number of outbound frames dropped by all
+// client write queues since the server is created.
+func (s *Server) DroppedFrames() uint64 {
+	return s.droppedFrames.Load()
+}
+
+// ClientDroppedFrames returns the number of outbound frames dropped by the
+// write queue of one client.
+func (s *Server) ClientDroppedFrames(clientUid uint32) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.clients[clientUid]
+	if !ok || c.queue == nil {
+		return 0, ErrClientNotFound
+	}
+	return c.queue.dropped.Load(), nil
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWriteQueueOverflow(t *testing.T) {
+	tests := []struct {
+		policy  OverflowPolicy
+		want    []string
+		wantErr error
+	}{
+		{DropOldest, []string{"reply", "b", "c"}, nil},
+		{DropNewest, []string{"reply", "a", "b"}, ErrWriteQueueFull},
+		{Disconnect, []string{"reply", "a", "b"}, ErrWriteQueueFull},
+	}
+
+	for _, tt := range tests {
+		// Replies count in the queue size
+		q := newWriteQueue(3, tt.policy)
+		q.push([]byte("reply"), false)
+		q.push([]byte("a"), true)
+		if dropped, err := q.push([]byte("b"), true); dropped || err != nil {
+			t.Fatalf("policy %d: push b dropped %v, err %v", tt.policy, dropped, err)
+		}
+
+		dropped, err := q.push([]byte("c"), true)
+		if !dropped || !errors.Is(err, tt.wantErr) {
+			t.Errorf("policy %d: push c dropped %v, err %v, want err %v", tt.policy, dropped, err, tt.wantErr)
+		}
+		if n := q.dropped.Load(); n != 1 {
+			t.Errorf("policy %d: dropped %d frames, want 1", tt.policy, n)
+		}
+
+		bufs, ok := q.take(nil)
+		if !ok || len(bufs) != len(tt.want) {
+			t.Fatalf("policy %d: take %q, want %q", tt.policy, bufs, tt.want)
+		}
+		for i := range bufs {
+			if string(bufs[i]) != tt.want[i] {
+				t.Errorf("policy %d: frame %d is %q, want %q", tt.policy, i, bufs[i], tt.want[i])
+			}
+		}
+
+		q.close()
+		if _, ok := q.take(nil); ok {
+			t.Errorf("policy %d: take after close", tt.policy)
+		}
+	}
+}
+
+func TestWriteQueueReplyOverflow(t *testing.T) {
+	for _, policy := range []OverflowPolicy{DropOldest, DropNewest, Disconnect} {
+		q := newWriteQueue(2, policy)
+		q.push([]byte("reply 1"), false)
+		q.push([]byte("reply 2"), false)
+
+		if _, err := q.push([]byte("reply 3"), false); err != ErrWriteQueueFull {
+			t.Errorf("policy %d: push reply to full queue, err %v, want ErrWriteQueueFull", policy, err)
+		}
+		if bufs, _ := q.take(nil); len(bufs) != 2 {
+			t.Errorf("policy %d: %d frames queued, want 2", policy, len(bufs))
+		}
+	}
+
+	// DropOldest makes room for a reply by dropping a publish
+	q := newWriteQueue(2, DropOldest)
+	q.push([]byte("reply 1"), false)
+	q.push([]byte("publish"), true)
+	if dropped, err := q.push([]byte("reply 2"), false); !dropped || err != nil {
+		t.Fatalf("push reply dropped %v, err %v", dropped, err)
+	}
+	bufs, _ := q.take(nil)
+	if len(bufs) != 2 || string(bufs[1]) != "reply 2" {
+		t.Errorf("take %q, want [reply 1 reply 2]", bufs)
+	}
+}